@@ -0,0 +1,83 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// AccessLog returns middleware that logs a line for each request with its method, path,
+// status, latency and the number of bytes written. The query string is not logged, since
+// it may contain tokens or personal information.
+//
+// It observes errors reported with httperr.ReportError, e.g. by httperr.HandlerFunc and
+// JSONHandler, using httperr.ObserveError, so they are still reported as before and are
+// also logged. Public errors are logged at the info level with their message. Other
+// errors are logged at the error level, along with the full error chain formatted with
+// %+v, which includes stack traces from github.com/pkg/errors. If the request has an ID
+// assigned by RequestID, it is logged too.
+//
+// e.g.
+//
+//   mux := goji.NewMux()
+//   mux.Use(AccessLog(slog.Default()))
+//
+func AccessLog(logger Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			tw := WrapResponseWriter(w)
+
+			var handlerErr error
+			r = httperr.ObserveError(r, func(err error) {
+				handlerErr = err
+			})
+			next.ServeHTTP(tw, r)
+
+			status := tw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			args := []interface{}{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"duration", time.Since(start),
+				"bytes", tw.BytesWritten(),
+				"remote_addr", r.RemoteAddr,
+			}
+			if id := RequestIDFromContext(r.Context()); id != "" {
+				args = append(args, "request_id", id)
+			}
+			switch {
+			case handlerErr == nil:
+				logger.InfoContext(r.Context(), "http request", args...)
+			case httperr.IsPublic(handlerErr):
+				logger.InfoContext(r.Context(), "http request", append(args,
+					"error", handlerErr.Error(),
+					"public", true)...)
+			default:
+				logger.ErrorContext(r.Context(), "http request", append(args,
+					"error", handlerErr.Error(),
+					"public", false,
+					"error_detail", fmt.Sprintf("%+v", handlerErr))...)
+			}
+		})
+	}
+}
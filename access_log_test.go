@@ -0,0 +1,93 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+func TestAccessLog(t *testing.T) {
+	logger := &testLogger{}
+	handler := AccessLog(logger)(httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.URL.Path {
+		case "/public":
+			return httperr.Publicf(http.StatusConflict, "widget already exists")
+		case "/private":
+			return errors.Wrap(errors.New("connection refused"), "cannot load widget")
+		}
+		_, err := w.Write([]byte("Hello, World!"))
+		return err
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path+"?a=b", nil))
+		return w
+	}
+
+	t.Run("success", func(t *testing.T) {
+		logger.records = nil
+		w := serve("/ok")
+		assert.Check(t, is.Equal(http.StatusOK, w.Code))
+
+		assert.Assert(t, is.Len(logger.records, 1))
+		record := logger.records[0]
+		assert.Check(t, is.Equal("info", record.Level))
+		assert.Check(t, is.Equal("GET", record.Attrs["method"]))
+		assert.Check(t, is.Equal("/ok", record.Attrs["path"]))
+		assert.Check(t, is.Equal(http.StatusOK, record.Attrs["status"]))
+		assert.Check(t, is.Equal(int64(13), record.Attrs["bytes"]))
+		assert.Check(t, is.Contains(record.Attrs, "duration"))
+		_, hasError := record.Attrs["error"]
+		assert.Check(t, !hasError)
+	})
+
+	t.Run("public error", func(t *testing.T) {
+		logger.records = nil
+		w := serve("/public")
+		assert.Check(t, is.Equal(http.StatusConflict, w.Code))
+		assert.Check(t, is.Equal("widget already exists", w.Header().Get("X-Error-Message")))
+
+		assert.Assert(t, is.Len(logger.records, 1))
+		record := logger.records[0]
+		assert.Check(t, is.Equal("info", record.Level))
+		assert.Check(t, is.Equal(http.StatusConflict, record.Attrs["status"]))
+		assert.Check(t, is.Equal("widget already exists", record.Attrs["error"]))
+		assert.Check(t, is.Equal(true, record.Attrs["public"]))
+	})
+
+	t.Run("private error", func(t *testing.T) {
+		logger.records = nil
+		w := serve("/private")
+		assert.Check(t, is.Equal(http.StatusInternalServerError, w.Code))
+		assert.Check(t, is.Equal("", w.Header().Get("X-Error-Message")))
+
+		assert.Assert(t, is.Len(logger.records, 1))
+		record := logger.records[0]
+		assert.Check(t, is.Equal("error", record.Level))
+		assert.Check(t, is.Equal(http.StatusInternalServerError, record.Attrs["status"]))
+		assert.Check(t, is.Equal("cannot load widget: connection refused", record.Attrs["error"]))
+		assert.Check(t, is.Equal(false, record.Attrs["public"]))
+		assert.Check(t, is.Contains(record.Attrs["error_detail"], "access_log_test.go"))
+	})
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BearerTokenTransport is an http.RoundTripper that adds a bearer token from Source to
+// the request.
+//
+// Tokens with a known expiry are cached until ExpiryDelta before they expire. Tokens
+// without an expiry are requested from Source for every request.
+//
+// e.g.
+//
+//   transport := &BearerTokenTransport{
+//      Next:   http.DefaultTransport,
+//      Source: &FileTokenSource{Path: "/var/run/secrets/tokens/api-token"},
+//   }
+//
+type BearerTokenTransport struct {
+	Next   http.RoundTripper
+	Source TokenSource
+
+	// ExpiryDelta is how long before expiry a token is requested again. If zero, 30s is used.
+	ExpiryDelta time.Duration
+
+	mu    sync.Mutex
+	token Token
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *BearerTokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	expiryDelta := t.ExpiryDelta
+	if expiryDelta == 0 {
+		expiryDelta = defaultTokenExpiryDelta
+	}
+
+	t.mu.Lock()
+	token := t.token
+	t.mu.Unlock()
+
+	if token.Value == "" || token.Expiry.IsZero() || !time.Now().Add(expiryDelta).Before(token.Expiry) {
+		var err error
+		token, err = t.Source.Token(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.token = token
+		t.mu.Unlock()
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+token.Value)
+	return t.Next.RoundTrip(r)
+}
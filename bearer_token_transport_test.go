@@ -0,0 +1,109 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+type countingTokenSource struct {
+	calls int
+	token Token
+}
+
+func (s *countingTokenSource) Token(ctx context.Context) (Token, error) {
+	s.calls++
+	return s.token, nil
+}
+
+func fakeJWT(exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"alice","exp":%d}`, exp.Unix())))
+	return "eyJhbGciOiJub25lIn0." + payload + ".c2ln"
+}
+
+func TestBearerTokenTransport(t *testing.T) {
+	echoToken := FakeServer(func(r *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: http.StatusOK}
+		resp.Body = ioutil.NopCloser(strings.NewReader(r.Header.Get("Authorization")))
+		return resp, nil
+	})
+	get := func(t *testing.T, transport http.RoundTripper) string {
+		client := http.Client{Transport: transport}
+		resp, err := client.Get("https://api.example.com/foo")
+		assert.NilError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		return string(body)
+	}
+
+	t.Run("static", func(t *testing.T) {
+		transport := &BearerTokenTransport{Next: echoToken, Source: StaticTokenSource("hunter2")}
+		assert.Equal(t, "Bearer hunter2", get(t, transport))
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("HTTPX_TEST_TOKEN", "hunter3")
+		transport := &BearerTokenTransport{Next: echoToken, Source: EnvTokenSource("HTTPX_TEST_TOKEN")}
+		assert.Equal(t, "Bearer hunter3", get(t, transport))
+
+		transport = &BearerTokenTransport{Next: echoToken, Source: EnvTokenSource("HTTPX_TEST_MISSING")}
+		_, err := (&http.Client{Transport: transport}).Get("https://api.example.com/foo")
+		assert.Check(t, is.ErrorContains(err, "environment variable HTTPX_TEST_MISSING is not set"))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		assert.NilError(t, os.WriteFile(path, []byte("first\n"), 0600))
+
+		transport := &BearerTokenTransport{Next: echoToken, Source: &FileTokenSource{Path: path}}
+		assert.Equal(t, "Bearer first", get(t, transport))
+
+		assert.NilError(t, os.WriteFile(path, []byte("second-token\n"), 0600))
+		assert.Equal(t, "Bearer second-token", get(t, transport))
+	})
+
+	t.Run("caches tokens with an expiry", func(t *testing.T) {
+		source := &countingTokenSource{token: Token{Value: "hunter2", Expiry: time.Now().Add(time.Hour)}}
+		transport := &BearerTokenTransport{Next: echoToken, Source: source}
+		assert.Equal(t, "Bearer hunter2", get(t, transport))
+		assert.Equal(t, "Bearer hunter2", get(t, transport))
+		assert.Equal(t, 1, source.calls)
+
+		source.token.Expiry = time.Now().Add(10 * time.Second)
+		transport = &BearerTokenTransport{Next: echoToken, Source: source}
+		get(t, transport)
+		get(t, transport)
+		assert.Equal(t, 3, source.calls)
+	})
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Check(t, jwtExpiry(fakeJWT(exp)).Equal(exp))
+	assert.Check(t, jwtExpiry("hunter2").IsZero())
+	assert.Check(t, jwtExpiry("a.b.c").IsZero())
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"goji.io/pattern"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// fieldBinding describes a struct field that is populated from a part of the request
+// other than the body.
+type fieldBinding struct {
+	index  []int
+	source string // "path", "query" or "header"
+	name   string
+}
+
+var bindingsCache sync.Map // map[reflect.Type][]fieldBinding
+
+// bindingsOf returns the fields of the struct type t that have path, query or header tags.
+// Unexported fields are ignored, as they cannot be set.
+func bindingsOf(t reflect.Type) []fieldBinding {
+	if v, ok := bindingsCache.Load(t); ok {
+		return v.([]fieldBinding)
+	}
+
+	var bindings []fieldBinding
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for _, b := range bindingsOf(field.Type) {
+				b.index = append([]int{i}, b.index...)
+				bindings = append(bindings, b)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		for _, source := range []string{"path", "query", "header"} {
+			if name, ok := field.Tag.Lookup(source); ok && name != "" && name != "-" {
+				bindings = append(bindings, fieldBinding{index: []int{i}, source: source, name: name})
+			}
+		}
+	}
+
+	bindingsCache.Store(t, bindings)
+	return bindings
+}
+
+// structValue dereferences v, which must be a pointer, until it reaches a struct,
+// allocating nil pointers along the way. It returns false if v does not refer
+// to a struct.
+func structValue(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if !v.CanSet() {
+				return v, false
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v, v.Kind() == reflect.Struct
+}
+
+// hasBindings returns true if v, a pointer, refers to a struct type with path,
+// query or header tags.
+func hasBindings(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && len(bindingsOf(t)) > 0
+}
+
+// bindRequest populates the fields of v, a pointer to a struct, that are tagged with
+// `path:"name"`, `query:"name"` or `header:"Name"` from the goji path variables, URL
+// query parameters and headers of r respectively. Missing values leave the field
+// unchanged. Conversion failures are reported as public 400 errors.
+func bindRequest(r *http.Request, v interface{}) error {
+	sv, ok := structValue(reflect.ValueOf(v))
+	if !ok {
+		return nil
+	}
+
+	var query map[string][]string
+	for _, b := range bindingsOf(sv.Type()) {
+		var values []string
+		switch b.source {
+		case "path":
+			if s, ok := r.Context().Value(pattern.Variable(b.name)).(string); ok {
+				values = []string{s}
+			}
+		case "query":
+			if query == nil {
+				query = r.URL.Query()
+			}
+			values = query[b.name]
+		case "header":
+			values = r.Header.Values(b.name)
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		if err := setField(sv.FieldByIndex(b.index), values); err != nil {
+			return httperr.Publicf(http.StatusBadRequest, "invalid %s parameter %q: %v", b.source, b.name, err)
+		}
+	}
+	return nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// setField converts values and stores them in field. Slices receive every value,
+// all other types receive the first.
+func setField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, s := range values {
+			if err := setValue(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setValue(field, values[0])
+}
+
+// setValue converts s to the type of v and stores it in v. time.Time values are
+// parsed as RFC 3339 via encoding.TextUnmarshaler.
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+type Pagination struct {
+	Limit  int     `query:"limit" json:"-"`
+	Cursor *string `query:"cursor" json:"-"`
+}
+
+type BoundInput struct {
+	Pagination
+	ID      string        `path:"id" json:"-"`
+	Tenant  string        `header:"X-Tenant" json:"-"`
+	Since   time.Time     `query:"since" json:"-"`
+	Timeout time.Duration `query:"timeout" json:"-"`
+	Verbose bool          `query:"verbose" json:"-"`
+	Tags    []string      `query:"tag" json:"-"`
+	Name    string
+}
+
+func TestBindRequest(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var got BoundInput
+		h := JSONInputHandlerFunc(func(r *http.Request, in BoundInput) error {
+			got = in
+			return nil
+		})
+
+		r := TestRequest(context.Background(), "id", "frob")
+		r.Method = "POST"
+		r.URL, _ = url.Parse("/frob?limit=10&cursor=abc&since=2021-01-02T03:04:05Z&timeout=5s&verbose=true&tag=a&tag=b")
+		r.Header.Set("X-Tenant", "acme")
+		r.Body = ioutil.NopCloser(strings.NewReader(`{"Name": "grob"}`))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		cursor := "abc"
+		assert.DeepEqual(t, got, BoundInput{
+			Pagination: Pagination{Limit: 10, Cursor: &cursor},
+			ID:         "frob",
+			Tenant:     "acme",
+			Since:      time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+			Timeout:    5 * time.Second,
+			Verbose:    true,
+			Tags:       []string{"a", "b"},
+			Name:       "grob",
+		})
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		var got *BoundInput
+		h := JSONInputHandlerFunc(func(r *http.Request, in *BoundInput) error {
+			got = in
+			return nil
+		})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/frob?limit=5", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Check(t, is.Equal(5, got.Limit))
+		assert.Check(t, is.Nil(got.Cursor))
+	})
+
+	t.Run("conversion error", func(t *testing.T) {
+		h := JSONInputHandlerFunc(func(r *http.Request, in BoundInput) error {
+			panic("not reached")
+		})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/frob?limit=lots", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, `invalid query parameter "limit": strconv.ParseInt: parsing "lots": invalid syntax`,
+			w.Header().Get("X-Error-Message"))
+	})
+
+	t.Run("unexported field", func(t *testing.T) {
+		type Input struct {
+			secret string `query:"s"`
+			Limit  int    `query:"limit"`
+		}
+		var got Input
+		h := JSONInputHandlerFunc(func(r *http.Request, in Input) error {
+			got = in
+			return nil
+		})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/frob?s=hunter2&limit=5", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Check(t, is.Equal("", got.secret))
+		assert.Check(t, is.Equal(5, got.Limit))
+	})
+
+	t.Run("reflect handler", func(t *testing.T) {
+		var got BoundInput
+		h := JSONHandler(func(r *http.Request, in BoundInput) error {
+			got = in
+			return nil
+		})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/frob?verbose=1", strings.NewReader(`{"Name": "grob"}`)))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Check(t, got.Verbose)
+		assert.Check(t, is.Equal("grob", got.Name))
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cache stores the responses cached by CachingTransport. Implementations must be safe
+// for concurrent use. Caching is best effort, so errors are not reported: a value that
+// cannot be stored or read is treated as missing.
+type Cache interface {
+	// Get returns the value stored for key. The caller must not modify it.
+	Get(key string) (value []byte, ok bool)
+
+	// Set stores value for key.
+	Set(key string, value []byte)
+
+	// Delete removes the value stored for key, if there is one.
+	Delete(key string)
+}
+
+// MemoryCache is a Cache that keeps values in memory. Once the total size of the values
+// exceeds MaxBytes, the least recently used values are removed. If MaxBytes is zero,
+// the size is not limited.
+type MemoryCache struct {
+	MaxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	items map[string]*list.Element
+	lru   list.List // of *memoryCacheItem, most recently used first
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+// Get implements Cache.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true
+}
+
+// Set implements Cache.
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	if c.MaxBytes > 0 && int64(len(value)) > c.MaxBytes {
+		return
+	}
+	if c.items == nil {
+		c.items = map[string]*list.Element{}
+	}
+	c.items[key] = c.lru.PushFront(&memoryCacheItem{key: key, value: value})
+	c.size += int64(len(value))
+	for c.MaxBytes > 0 && c.size > c.MaxBytes {
+		c.remove(c.lru.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+// Delete implements Cache.
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+// remove removes key from the cache. c.mu must be held.
+func (c *MemoryCache) remove(key string) {
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(e)
+	delete(c.items, key)
+	c.size -= int64(len(e.Value.(*memoryCacheItem).value))
+}
+
+// DiskCache is a Cache that stores each value in a file in Dir, which is created if
+// it does not exist. Files are named after the SHA-256 hash of their key.
+type DiskCache struct {
+	Dir string
+}
+
+// Get implements Cache.
+func (c DiskCache) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set implements Cache.
+func (c DiskCache) Set(key string, value []byte) {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return
+	}
+	// write to a temporary file first, so that readers never see a partial value
+	f, err := ioutil.TempFile(c.Dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete implements Cache.
+func (c DiskCache) Delete(key string) {
+	os.Remove(c.path(key))
+}
+
+func (c DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
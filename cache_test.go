@@ -0,0 +1,72 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestMemoryCache(t *testing.T) {
+	c := &MemoryCache{MaxBytes: 10}
+	c.Set("a", []byte("1234"))
+	c.Set("b", []byte("5678"))
+
+	value, ok := c.Get("a") // a is now the most recently used
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal("1234", string(value)))
+
+	c.Set("c", []byte("90"))
+	c.Set("d", []byte("12")) // evicts b
+	_, ok = c.Get("b")
+	assert.Check(t, !ok)
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok := c.Get(key)
+		assert.Check(t, ok, key)
+	}
+
+	c.Set("e", []byte("too large to cache"))
+	_, ok = c.Get("e")
+	assert.Check(t, !ok)
+
+	c.Set("a", []byte("1"))
+	value, _ = c.Get("a")
+	assert.Check(t, is.Equal("1", string(value)))
+	c.Delete("a")
+	_, ok = c.Get("a")
+	assert.Check(t, !ok)
+	assert.Check(t, is.Equal(int64(4), c.size))
+}
+
+func TestDiskCache(t *testing.T) {
+	c := DiskCache{Dir: t.TempDir() + "/cache"}
+	_, ok := c.Get("https://example.com/")
+	assert.Check(t, !ok)
+
+	c.Set("https://example.com/", []byte("Hello, World!"))
+	value, ok := c.Get("https://example.com/")
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal("Hello, World!", string(value)))
+
+	c.Set("https://example.com/", []byte("Goodbye"))
+	value, _ = c.Get("https://example.com/")
+	assert.Check(t, is.Equal("Goodbye", string(value)))
+
+	c.Delete("https://example.com/")
+	_, ok = c.Get("https://example.com/")
+	assert.Check(t, !ok)
+}
@@ -0,0 +1,487 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHeuristicFreshness limits the freshness lifetime computed from Last-Modified for
+// responses without an explicit lifetime.
+const maxHeuristicFreshness = 24 * time.Hour
+
+// CachingTransport is an http.RoundTripper that caches responses to GET requests in
+// Cache, following the rules of RFC 7234.
+//
+// A cached response is used while it is fresh, according to the max-age directive, the
+// Expires header, or failing those, a tenth of the time since Last-Modified. Responses
+// with no-store are not cached, and responses with no-cache are revalidated every time
+// they are used. Requests with no-store bypass the cache, and requests with no-cache or
+// max-age bypass or limit the use of fresh responses.
+//
+// A stale response is revalidated using If-None-Match and If-Modified-Since, if it has an
+// ETag or Last-Modified header. Within the window allowed by stale-while-revalidate, it
+// is used while it is revalidated in the background, and within the window allowed by
+// stale-if-error, it is used if the request fails or the server responds with a 500,
+// 502, 503 or 504 error, unless it has must-revalidate.
+//
+// Only one variant of each URL is cached: a response whose Vary header names request
+// headers is only used for requests with the same values of those headers. Successful
+// requests with unsafe methods, e.g. POST, remove the cached response for their URL.
+//
+// If Shared is set, the cache behaves as a shared cache: s-maxage takes precedence over
+// max-age, and responses that are private, or to requests with an Authorization header,
+// are not cached unless they allow it. Otherwise s-maxage is ignored, and responses to
+// requests with an Authorization header are cached separately for each value of the
+// header, so that callers with different credentials never see each other's responses.
+// An unsafe request then only removes the cached response for its own credentials.
+//
+// Response bodies are read into memory before they are cached. Requests with Range or
+// conditional headers are sent unchanged.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7234
+// ref: https://www.rfc-editor.org/rfc/rfc5861
+//
+// e.g.
+//
+//   transport := &CachingTransport{
+//      Next:  http.DefaultTransport,
+//      Cache: &MemoryCache{MaxBytes: 64 << 20},
+//   }
+//
+type CachingTransport struct {
+	Next   http.RoundTripper
+	Cache  Cache
+	Shared bool
+
+	clock        func() time.Time // for tests; time.Now if nil
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// cacheEntry is a response stored in the Cache.
+type cacheEntry struct {
+	RequestTime  time.Time   `json:"request_time"`
+	ResponseTime time.Time   `json:"response_time"`
+	Vary         http.Header `json:"vary,omitempty"` // request headers named by Vary
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *CachingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	key := t.cacheKey(r)
+
+	if r.Method != http.MethodGet && r.Method != "" {
+		resp, err := t.Next.RoundTrip(r)
+		if err == nil && !isSafeMethod(r.Method) && resp.StatusCode < 400 {
+			t.Cache.Delete(key)
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(r.Header)
+	if _, noStore := reqCC["no-store"]; noStore || r.Header.Get("Range") != "" || isConditional(r) {
+		return t.Next.RoundTrip(r)
+	}
+
+	entry := t.load(key, r)
+	if entry == nil {
+		return t.fetch(r, key, nil)
+	}
+
+	now := t.now()
+	age := entry.age(now)
+	lifetime := entry.freshnessLifetime(t.Shared)
+	if maxAge, ok := directiveDuration(reqCC, "max-age"); ok && maxAge < lifetime {
+		lifetime = maxAge
+	}
+	respCC := parseCacheControl(entry.Header)
+	_, reqNoCache := reqCC["no-cache"]
+	if len(reqCC) == 0 && r.Header.Get("Pragma") == "no-cache" {
+		reqNoCache = true
+	}
+	_, respNoCache := respCC["no-cache"]
+
+	if !reqNoCache && !respNoCache && age < lifetime {
+		return entry.response(r, age), nil
+	}
+
+	swr, _ := directiveDuration(respCC, "stale-while-revalidate")
+	if !reqNoCache && !t.mustRevalidate(respCC) && age-lifetime < swr {
+		resp := entry.response(r, age)
+		t.revalidateInBackground(r, key, entry)
+		return resp, nil
+	}
+	return t.fetch(r, key, entry)
+}
+
+// fetch sends r, revalidating entry if it is not nil, and caches the response.
+func (t *CachingTransport) fetch(r *http.Request, key string, entry *cacheEntry) (*http.Response, error) {
+	req := r
+	if entry != nil {
+		req = r.Clone(r.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := t.now()
+	resp, err := t.Next.RoundTrip(req)
+	if entry != nil && t.canUseStaleOnError(r, entry, resp, err) {
+		if err == nil {
+			discardBody(resp)
+		}
+		return entry.response(r, entry.age(t.now())), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	responseTime := t.now()
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		discardBody(resp)
+		for name, values := range resp.Header {
+			if name != "Content-Length" {
+				entry.Header[name] = values
+			}
+		}
+		entry.RequestTime, entry.ResponseTime = requestTime, responseTime
+		t.store(key, entry)
+		return entry.response(r, entry.age(t.now())), nil
+	}
+
+	if !t.isStorable(r, resp) {
+		if _, noStore := parseCacheControl(resp.Header)["no-store"]; noStore {
+			t.Cache.Delete(key)
+		}
+		return resp, nil
+	}
+
+	body, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry = &cacheEntry{
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if entry.Vary == nil {
+			entry.Vary = http.Header{}
+		}
+		entry.Vary[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+	}
+	t.store(key, entry)
+	return resp, nil
+}
+
+// revalidateInBackground revalidates entry, unless it is already being revalidated.
+func (t *CachingTransport) revalidateInBackground(r *http.Request, key string, entry *cacheEntry) {
+	t.mu.Lock()
+	if t.revalidating[key] {
+		t.mu.Unlock()
+		return
+	}
+	if t.revalidating == nil {
+		t.revalidating = map[string]bool{}
+	}
+	t.revalidating[key] = true
+	t.mu.Unlock()
+
+	// the request may be cancelled as soon as the stale response has been read
+	req := r.Clone(context.Background())
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.revalidating, key)
+			t.mu.Unlock()
+		}()
+		if resp, err := t.fetch(req, key, entry); err == nil {
+			discardBody(resp)
+		}
+	}()
+}
+
+func (t *CachingTransport) canUseStaleOnError(r *http.Request, entry *cacheEntry, resp *http.Response, err error) bool {
+	if err == nil {
+		switch resp.StatusCode {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		default:
+			return false
+		}
+	}
+
+	respCC := parseCacheControl(entry.Header)
+	if t.mustRevalidate(respCC) {
+		return false
+	}
+	sie, ok := directiveDuration(parseCacheControl(r.Header), "stale-if-error")
+	if !ok {
+		sie, _ = directiveDuration(respCC, "stale-if-error")
+	}
+	return entry.age(t.now())-entry.freshnessLifetime(t.Shared) < sie
+}
+
+func (t *CachingTransport) mustRevalidate(cc map[string]string) bool {
+	_, mustRevalidate := cc["must-revalidate"]
+	_, proxyRevalidate := cc["proxy-revalidate"]
+	_, noCache := cc["no-cache"]
+	return mustRevalidate || noCache || (t.Shared && proxyRevalidate)
+}
+
+// isStorable returns true if resp may be stored in the cache.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7234#section-3
+func (t *CachingTransport) isStorable(r *http.Request, resp *http.Response) bool {
+	cc := parseCacheControl(resp.Header)
+	if _, noStore := cc["no-store"]; noStore {
+		return false
+	}
+	_, public := cc["public"]
+	_, hasSMaxAge := cc["s-maxage"]
+	if t.Shared {
+		if _, private := cc["private"]; private {
+			return false
+		}
+		if _, mustRevalidate := cc["must-revalidate"]; r.Header.Get("Authorization") != "" && !public && !hasSMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	_, hasMaxAge := cc["max-age"]
+	explicit := hasMaxAge || (t.Shared && hasSMaxAge) || resp.Header.Get("Expires") != ""
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusGone, http.StatusRequestURITooLong, http.StatusNotImplemented:
+		// cacheable by default
+	case http.StatusPartialContent:
+		return false
+	default:
+		if !explicit && !public {
+			return false
+		}
+	}
+
+	// a response that is never fresh and cannot be revalidated is of no use
+	return explicit || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+func (t *CachingTransport) load(key string, r *http.Request) *cacheEntry {
+	buf, ok := t.Cache.Get(key)
+	if !ok {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return nil
+	}
+	for name, values := range entry.Vary {
+		if strings.Join(r.Header.Values(name), ",") != strings.Join(values, ",") {
+			return nil
+		}
+	}
+	return &entry
+}
+
+func (t *CachingTransport) store(key string, entry *cacheEntry) {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	t.Cache.Set(key, buf)
+}
+
+func (t *CachingTransport) now() time.Time {
+	if t.clock != nil {
+		return t.clock()
+	}
+	return time.Now()
+}
+
+// age returns the current age of the response.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7234#section-4.2.3
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparentAge = e.ResponseTime.Sub(date)
+	}
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime returns how long the response is fresh for.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7234#section-4.2.1
+func (e *cacheEntry) freshnessLifetime(shared bool) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if shared {
+		if d, ok := directiveDuration(cc, "s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := directiveDuration(cc, "max-age"); ok {
+		return d
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if expiresHeader := e.Header.Get("Expires"); expiresHeader != "" {
+		// an invalid Expires header, e.g. "0", means already expired
+		expires, err := http.ParseTime(expiresHeader)
+		if err != nil || expires.Before(date) {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && lastModified.Before(date) {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > maxHeuristicFreshness {
+			lifetime = maxHeuristicFreshness
+		}
+		return lifetime
+	}
+	return 0
+}
+
+// response returns the cached response to r.
+func (e *cacheEntry) response(r *http.Request, age time.Duration) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       r,
+	}
+}
+
+// cacheKey returns the key of the cached response to a GET request for the URL of r. For
+// a private cache, it includes a digest of the Authorization header, if there is one.
+func (t *CachingTransport) cacheKey(r *http.Request) string {
+	u := *r.URL
+	u.Fragment = ""
+	u.RawFragment = ""
+	key := u.String()
+	if auth := r.Header.Get("Authorization"); auth != "" && !t.Shared {
+		digest := sha256.Sum256([]byte(auth))
+		key += " authorization=" + hex.EncodeToString(digest[:])
+	}
+	return key
+}
+
+// parseCacheControl returns the directives in the Cache-Control header, by lowercase
+// name. Directives without an argument have the value "".
+func parseCacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return cc
+}
+
+// directiveDuration returns the value of a directive that is a number of seconds.
+func directiveDuration(cc map[string]string, name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// varyHeaders returns the names listed in the Vary header.
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func isConditional(r *http.Request) bool {
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func discardBody(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
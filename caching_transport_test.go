@@ -0,0 +1,347 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeOrigin is a server whose responses are set by tests, and which counts and
+// remembers the requests it receives.
+type fakeOrigin struct {
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	body     string
+	err      error
+	requests []*http.Request
+	received chan struct{}
+}
+
+func (o *fakeOrigin) RoundTrip(r *http.Request) (*http.Response, error) {
+	o.mu.Lock()
+	defer func() {
+		o.mu.Unlock()
+		select {
+		case o.received <- struct{}{}:
+		default:
+		}
+	}()
+	o.requests = append(o.requests, r)
+	if o.err != nil {
+		return nil, o.err
+	}
+
+	status := o.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := fmt.Sprintf("%s #%d", o.body, len(o.requests))
+	if etag := o.header.Get("ETag"); etag != "" && r.Header.Get("If-None-Match") == etag {
+		status, body = http.StatusNotModified, ""
+	}
+	if lm := o.header.Get("Last-Modified"); lm != "" && r.Header.Get("If-Modified-Since") == lm && o.header.Get("ETag") == "" {
+		status, body = http.StatusNotModified, ""
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Header:        o.header.Clone(),
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+func (o *fakeOrigin) count() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.requests)
+}
+
+func (o *fakeOrigin) last() *http.Request {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[len(o.requests)-1]
+}
+
+func TestCachingTransport(t *testing.T) {
+	setup := func(header http.Header) (*fakeOrigin, *fakeClock, *CachingTransport) {
+		origin := &fakeOrigin{header: header, body: "hello", received: make(chan struct{}, 1)}
+		clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+		transport := &CachingTransport{Next: origin, Cache: &MemoryCache{}, clock: clock.Now}
+		return origin, clock, transport
+	}
+	get := func(t *testing.T, transport http.RoundTripper, header http.Header) (*http.Response, string) {
+		t.Helper()
+		r, err := http.NewRequest("GET", "https://api.example.com/foo", nil)
+		assert.NilError(t, err)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		resp, err := transport.RoundTrip(r)
+		assert.NilError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		return resp, string(body)
+	}
+
+	t.Run("max-age and revalidation", func(t *testing.T) {
+		origin, clock, transport := setup(http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}})
+
+		_, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+
+		clock.Advance(30 * time.Second)
+		resp, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal("30", resp.Header.Get("Age")))
+		assert.Check(t, is.Equal(1, origin.count()))
+
+		clock.Advance(time.Minute)
+		resp, body = get(t, transport, nil)
+		assert.Check(t, is.Equal(http.StatusOK, resp.StatusCode))
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal("0", resp.Header.Get("Age")))
+		assert.Check(t, is.Equal(2, origin.count()))
+		assert.Check(t, is.Equal(`"v1"`, origin.last().Header.Get("If-None-Match")))
+
+		// the 304 refreshed the stored response
+		clock.Advance(30 * time.Second)
+		_, body = get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal(2, origin.count()))
+	})
+
+	t.Run("last-modified", func(t *testing.T) {
+		lastModified := time.Date(2019, 12, 28, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+		origin, clock, transport := setup(http.Header{
+			"Date":          {time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)},
+			"Last-Modified": {lastModified},
+		})
+
+		// heuristically fresh for a tenth of the 5 days since it was modified
+		get(t, transport, nil)
+		clock.Advance(6 * time.Hour)
+		_, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal(1, origin.count()))
+
+		clock.Advance(7 * time.Hour)
+		_, body = get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal(2, origin.count()))
+		assert.Check(t, is.Equal(lastModified, origin.last().Header.Get("If-Modified-Since")))
+	})
+
+	t.Run("no-store", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"no-store, max-age=60"}})
+		get(t, transport, nil)
+		_, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #2", body))
+		assert.Check(t, is.Equal(2, origin.count()))
+	})
+
+	t.Run("no-cache", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"no-cache, max-age=60"}, "Etag": {`"v1"`}})
+		get(t, transport, nil)
+		_, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal(2, origin.count()))
+		assert.Check(t, is.Equal(`"v1"`, origin.last().Header.Get("If-None-Match")))
+	})
+
+	t.Run("request directives", func(t *testing.T) {
+		origin, clock, transport := setup(http.Header{"Cache-Control": {"max-age=60"}})
+		get(t, transport, nil)
+
+		_, body := get(t, transport, http.Header{"Cache-Control": {"no-store"}})
+		assert.Check(t, is.Equal("hello #2", body))
+
+		_, body = get(t, transport, http.Header{"Cache-Control": {"no-cache"}})
+		assert.Check(t, is.Equal("hello #3", body))
+
+		clock.Advance(20 * time.Second)
+		_, body = get(t, transport, http.Header{"Cache-Control": {"max-age=30"}})
+		assert.Check(t, is.Equal("hello #3", body))
+		_, body = get(t, transport, http.Header{"Cache-Control": {"max-age=10"}})
+		assert.Check(t, is.Equal("hello #4", body))
+		assert.Check(t, is.Equal(4, origin.count()))
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}})
+		get(t, transport, http.Header{"Accept-Language": {"en"}})
+		_, body := get(t, transport, http.Header{"Accept-Language": {"en"}})
+		assert.Check(t, is.Equal("hello #1", body))
+		_, body = get(t, transport, http.Header{"Accept-Language": {"fr"}})
+		assert.Check(t, is.Equal("hello #2", body))
+		assert.Check(t, is.Equal(2, origin.count()))
+	})
+
+	t.Run("s-maxage", func(t *testing.T) {
+		header := http.Header{"Cache-Control": {"max-age=10, s-maxage=60"}}
+
+		origin, clock, transport := setup(header)
+		get(t, transport, nil)
+		clock.Advance(30 * time.Second)
+		get(t, transport, nil)
+		assert.Check(t, is.Equal(2, origin.count()))
+
+		origin, clock, transport = setup(header)
+		transport.Shared = true
+		get(t, transport, nil)
+		clock.Advance(30 * time.Second)
+		get(t, transport, nil)
+		assert.Check(t, is.Equal(1, origin.count()))
+	})
+
+	t.Run("shared private", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"private, max-age=60"}})
+		transport.Shared = true
+		get(t, transport, nil)
+		get(t, transport, nil)
+		assert.Check(t, is.Equal(2, origin.count()))
+	})
+
+	t.Run("private authorization", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"max-age=60"}})
+		alice := http.Header{"Authorization": {"Bearer alice"}}
+		bob := http.Header{"Authorization": {"Bearer bob"}}
+		_, body := get(t, transport, alice)
+		assert.Check(t, is.Equal("hello #1", body))
+		_, body = get(t, transport, bob)
+		assert.Check(t, is.Equal("hello #2", body))
+		_, body = get(t, transport, alice)
+		assert.Check(t, is.Equal("hello #1", body))
+		_, body = get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #3", body))
+		assert.Check(t, is.Equal(3, origin.count()))
+	})
+
+	t.Run("stale-while-revalidate", func(t *testing.T) {
+		origin, clock, transport := setup(http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=30"}})
+		get(t, transport, nil)
+		<-origin.received
+
+		clock.Advance(70 * time.Second)
+		resp, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal("70", resp.Header.Get("Age")))
+
+		<-origin.received // the background revalidation
+		assert.Check(t, is.Equal(2, origin.count()))
+		assert.Assert(t, pollUntil(func() bool {
+			transport.mu.Lock()
+			defer transport.mu.Unlock()
+			return len(transport.revalidating) == 0
+		}))
+		_, body = get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #2", body))
+
+		clock.Advance(100 * time.Second)
+		_, body = get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #3", body))
+	})
+
+	t.Run("stale-if-error", func(t *testing.T) {
+		origin, clock, transport := setup(http.Header{"Cache-Control": {"max-age=60, stale-if-error=60"}})
+		get(t, transport, nil)
+
+		clock.Advance(90 * time.Second)
+		origin.status = http.StatusServiceUnavailable
+		resp, body := get(t, transport, nil)
+		assert.Check(t, is.Equal(http.StatusOK, resp.StatusCode))
+		assert.Check(t, is.Equal("hello #1", body))
+
+		origin.status, origin.err = 0, errors.New("connection refused")
+		_, body = get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+
+		clock.Advance(60 * time.Second)
+		_, err := transport.RoundTrip(origin.last().Clone(origin.last().Context()))
+		assert.Check(t, is.ErrorContains(err, "connection refused"))
+	})
+
+	t.Run("must-revalidate", func(t *testing.T) {
+		origin, clock, transport := setup(http.Header{"Cache-Control": {"max-age=60, must-revalidate, stale-if-error=60"}})
+		get(t, transport, nil)
+		clock.Advance(90 * time.Second)
+		origin.status = http.StatusServiceUnavailable
+		resp, _ := get(t, transport, nil)
+		assert.Check(t, is.Equal(http.StatusServiceUnavailable, resp.StatusCode))
+	})
+
+	t.Run("unsafe methods invalidate", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"max-age=60"}})
+		get(t, transport, nil)
+
+		r, err := http.NewRequest("POST", "https://api.example.com/foo", strings.NewReader("{}"))
+		assert.NilError(t, err)
+		resp, err := transport.RoundTrip(r)
+		assert.NilError(t, err)
+		discardBody(resp)
+
+		_, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #3", body))
+		assert.Check(t, is.Equal(3, origin.count()))
+	})
+
+	t.Run("disk cache", func(t *testing.T) {
+		origin, _, transport := setup(http.Header{"Cache-Control": {"max-age=60"}})
+		transport.Cache = DiskCache{Dir: t.TempDir()}
+		get(t, transport, nil)
+		_, body := get(t, transport, nil)
+		assert.Check(t, is.Equal("hello #1", body))
+		assert.Check(t, is.Equal(1, origin.count()))
+	})
+}
+
+// pollUntil returns true once f does, or false if it does not within a second.
+func pollUntil(f func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if f() {
+			return true
+		}
+	}
+	return false
+}
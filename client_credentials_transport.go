@@ -0,0 +1,226 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenExpiryDelta = 30 * time.Second
+	defaultTokenTimeout     = 30 * time.Second
+)
+
+// ClientCredentialsTransport is an http.RoundTripper that authenticates requests with a
+// bearer token obtained from an OAuth2 token endpoint using the client credentials grant.
+//
+// Tokens are cached until ExpiryDelta before they expire. Concurrent requests that need a
+// new token share a single request to the token endpoint. If a request is rejected with
+// 401 Unauthorized, the token is refreshed and the request is retried once.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc6749#section-4.4
+//
+// e.g.
+//
+//   transport := &ClientCredentialsTransport{
+//      Next:         http.DefaultTransport,
+//      TokenURL:     "https://auth.example.com/oauth2/token",
+//      ClientID:     "alice",
+//      ClientSecret: "hunter2",
+//      Scopes:       []string{"widgets:read"},
+//   }
+//
+type ClientCredentialsTransport struct {
+	Next         http.RoundTripper
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// Client is used to request tokens. If nil, requests are sent using Next.
+	Client *http.Client
+
+	// ExpiryDelta is how long before expiry a token is refreshed. If zero, 30s is used.
+	ExpiryDelta time.Duration
+
+	// TokenTimeout limits requests to the token endpoint. Such a request is shared by
+	// every request waiting for a token, so it is not cancelled with any one of them.
+	// If zero, 30s is used.
+	TokenTimeout time.Duration
+
+	mu       sync.Mutex
+	token    *oauth2Token
+	inflight *tokenCall
+}
+
+// oauth2Token is a token obtained from the token endpoint.
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+
+	expiry time.Time // zero if the token does not expire
+}
+
+// tokenCall is a request to the token endpoint that concurrent callers wait for.
+type tokenCall struct {
+	done  chan struct{}
+	token *oauth2Token
+	err   error
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ClientCredentialsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.getToken(r.Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Next.RoundTrip(t.authorize(r, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the token may have been revoked, so fetch a new one and try again
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return resp, nil
+	}
+	token, err = t.getToken(r.Context(), token)
+	if err != nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry := t.authorize(r, token)
+	if r.Body != nil && r.Body != http.NoBody {
+		if retry.Body, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.Next.RoundTrip(retry)
+}
+
+// authorize returns a copy of r with token in the Authorization header.
+func (t *ClientCredentialsTransport) authorize(r *http.Request, token *oauth2Token) *http.Request {
+	r = r.Clone(r.Context())
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	r.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return r
+}
+
+// getToken returns a cached token, or fetches a new one. If rejected is not nil, it is a
+// token that the server did not accept, so it is not returned again.
+func (t *ClientCredentialsTransport) getToken(ctx context.Context, rejected *oauth2Token) (*oauth2Token, error) {
+	expiryDelta := t.ExpiryDelta
+	if expiryDelta == 0 {
+		expiryDelta = defaultTokenExpiryDelta
+	}
+
+	t.mu.Lock()
+	if t.token != nil && t.token == rejected {
+		t.token = nil
+	}
+	if t.token != nil && (t.token.expiry.IsZero() || time.Now().Add(expiryDelta).Before(t.token.expiry)) {
+		token := t.token
+		t.mu.Unlock()
+		return token, nil
+	}
+
+	// wait for a concurrent request for a token, or start one
+	call := t.inflight
+	if call == nil {
+		call = &tokenCall{done: make(chan struct{})}
+		t.inflight = call
+		go t.runTokenCall(call)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runTokenCall fetches a token for call and caches it. The request uses its own context,
+// so that it is not cancelled with the request that happened to start it.
+func (t *ClientCredentialsTransport) runTokenCall(call *tokenCall) {
+	timeout := t.TokenTimeout
+	if timeout == 0 {
+		timeout = defaultTokenTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	call.token, call.err = t.fetchToken(ctx)
+
+	t.mu.Lock()
+	if call.err == nil {
+		t.token = call.token
+	}
+	t.inflight = nil
+	t.mu.Unlock()
+	close(call.done)
+}
+
+// fetchToken requests a new token from the token endpoint.
+func (t *ClientCredentialsTransport) fetchToken(ctx context.Context) (*oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(t.ClientID), url.QueryEscape(t.ClientSecret))
+
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Transport: t.Next}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	token := &oauth2Token{}
+	if err := (JSONClient{Client: client}).HandleResponse(resp, token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("token endpoint response does not contain an access_token")
+	}
+	if token.ExpiresIn > 0 {
+		token.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
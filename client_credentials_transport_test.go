@@ -0,0 +1,183 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// fakeTokenServer returns a FakeServer that issues tokens "t1", "t2", ... from /token
+// and accepts API requests bearing any token in valid.
+func fakeTokenServer(t *testing.T, fetches *int32, expiresIn int, valid func(token string) bool) FakeServer {
+	return FakeServer(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/token" {
+			n := atomic.AddInt32(fetches, 1)
+			time.Sleep(10 * time.Millisecond)
+
+			username, password, ok := r.BasicAuth()
+			assert.Check(t, ok)
+			assert.Check(t, is.Equal("alice", username))
+			assert.Check(t, is.Equal("hunter2", password))
+			assert.Check(t, r.ParseForm())
+			assert.Check(t, is.Equal("client_credentials", r.PostForm.Get("grant_type")))
+			assert.Check(t, is.Equal("widgets:read widgets:write", r.PostForm.Get("scope")))
+
+			resp := &http.Response{StatusCode: http.StatusOK}
+			resp.Body = ioutil.NopCloser(strings.NewReader(fmt.Sprintf(
+				`{"access_token": "t%d", "token_type": "bearer", "expires_in": %d}`, n, expiresIn)))
+			return resp, nil
+		}
+
+		kind, token := SplitAuthorizationHeader(r)
+		assert.Check(t, is.Equal("bearer", kind))
+		resp := &http.Response{StatusCode: http.StatusOK}
+		if !valid(token) {
+			resp.StatusCode = http.StatusUnauthorized
+		}
+		reqBody := ""
+		if r.Body != nil {
+			buf, _ := ioutil.ReadAll(r.Body)
+			reqBody = string(buf)
+		}
+		resp.Body = ioutil.NopCloser(strings.NewReader(fmt.Sprintf(`{"Bar": %q}`, token+reqBody)))
+		return resp, nil
+	})
+}
+
+func TestClientCredentialsTransport(t *testing.T) {
+	newTransport := func(next http.RoundTripper) *ClientCredentialsTransport {
+		return &ClientCredentialsTransport{
+			Next:         next,
+			TokenURL:     "https://auth.example.com/token",
+			ClientID:     "alice",
+			ClientSecret: "hunter2",
+			Scopes:       []string{"widgets:read", "widgets:write"},
+		}
+	}
+
+	t.Run("caches tokens", func(t *testing.T) {
+		var fetches int32
+		c := JSONClient{Client: &http.Client{Transport: newTransport(
+			fakeTokenServer(t, &fetches, 3600, func(string) bool { return true }))}}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := Get[ResponseBody](context.Background(), c, "https://api.example.com/foo")
+				assert.Check(t, err)
+				assert.Check(t, is.Equal("t1", resp.Bar))
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), fetches)
+	})
+
+	t.Run("refreshes expiring tokens", func(t *testing.T) {
+		var fetches int32
+		c := JSONClient{Client: &http.Client{Transport: newTransport(
+			fakeTokenServer(t, &fetches, 10, func(string) bool { return true }))}}
+
+		for i := 1; i <= 2; i++ {
+			resp, err := Get[ResponseBody](context.Background(), c, "https://api.example.com/foo")
+			assert.NilError(t, err)
+			assert.Equal(t, fmt.Sprintf("t%d", i), resp.Bar)
+		}
+	})
+
+	t.Run("retries once on 401", func(t *testing.T) {
+		var fetches int32
+		c := JSONClient{Client: &http.Client{Transport: newTransport(
+			fakeTokenServer(t, &fetches, 3600, func(token string) bool { return token != "t1" }))}}
+
+		resp, err := Post[RequestBody, ResponseBody](context.Background(), c, "https://api.example.com/foo", RequestBody{Foo: "x"})
+		assert.NilError(t, err)
+		assert.Equal(t, `t2{"Foo":"x"}`, resp.Bar)
+		assert.Equal(t, int32(2), fetches)
+
+		c = JSONClient{Client: &http.Client{Transport: newTransport(
+			fakeTokenServer(t, &fetches, 3600, func(token string) bool { return false }))}}
+		_, err = Get[ResponseBody](context.Background(), c, "https://api.example.com/foo")
+		assert.Check(t, is.Equal(http.StatusUnauthorized, err.(httperr.Response).StatusCode))
+	})
+
+	t.Run("first caller cancelled", func(t *testing.T) {
+		var fetches int32
+		tokenServer := fakeTokenServer(t, &fetches, 3600, func(string) bool { return true })
+		fetching := make(chan struct{})
+		release := make(chan struct{})
+		c := JSONClient{Client: &http.Client{Transport: newTransport(
+			FakeServer(func(r *http.Request) (*http.Response, error) {
+				if r.URL.Path == "/token" {
+					close(fetching)
+					select {
+					case <-release:
+					case <-r.Context().Done():
+						return nil, r.Context().Err()
+					}
+				}
+				return tokenServer(r)
+			}))}}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		firstErr := make(chan error)
+		go func() {
+			_, err := Get[ResponseBody](ctx, c, "https://api.example.com/foo")
+			firstErr <- err
+		}()
+		<-fetching
+
+		secondResp := make(chan *ResponseBody)
+		go func() {
+			resp, err := Get[ResponseBody](context.Background(), c, "https://api.example.com/foo")
+			assert.Check(t, err)
+			secondResp <- resp
+		}()
+
+		cancel()
+		err := <-firstErr
+		assert.Check(t, errors.Is(err, context.Canceled), err)
+		close(release)
+		assert.Check(t, is.DeepEqual(&ResponseBody{Bar: "t1"}, <-secondResp))
+		assert.Equal(t, int32(1), fetches)
+	})
+
+	t.Run("token endpoint error", func(t *testing.T) {
+		transport := newTransport(FakeServer(func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "/token", r.URL.Path)
+			resp := &http.Response{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}
+			resp.Body = ioutil.NopCloser(strings.NewReader(`{"error": "invalid_client"}`))
+			return resp, nil
+		}))
+		client := http.Client{Transport: transport}
+		_, err := client.Get("https://api.example.com/foo")
+		assert.Check(t, is.ErrorContains(err, "400 Bad Request"))
+	})
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// Digest algorithms, as registered in RFC 9530 section 7.2.
+const (
+	DigestAlgorithmSHA256 = "sha-256"
+	DigestAlgorithmSHA512 = "sha-512"
+)
+
+// ContentDigestTransport is an http.RoundTripper that adds a Content-Digest header to
+// requests with a body, and verifies the Content-Digest and Repr-Digest headers of
+// responses.
+//
+// Algorithms lists the digest algorithms to send, and defaults to sha-256. Response
+// digests are checked as the body is read, so reading a body that does not match its
+// digest fails with an error at the end of the body. Responses without digests, or
+// with only unsupported algorithms, are not checked. Neither are responses without a
+// body, i.e. to HEAD requests or with status 204 No Content or 304 Not Modified, whose
+// digests describe a body that was not sent.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc9530
+//
+// e.g.
+//
+//   transport := ContentDigestTransport{
+//      Next:       http.DefaultTransport,
+//      Algorithms: []string{DigestAlgorithmSHA256, DigestAlgorithmSHA512},
+//   }
+//
+type ContentDigestTransport struct {
+	Next       http.RoundTripper
+	Algorithms []string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t ContentDigestTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil && r.Body != http.NoBody {
+		r = r.Clone(r.Context())
+		body, err := readBody(&r.Body)
+		if err != nil {
+			return nil, err
+		}
+		algorithms := t.Algorithms
+		if len(algorithms) == 0 {
+			algorithms = []string{DigestAlgorithmSHA256}
+		}
+		value, err := digestHeader(algorithms, body)
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Digest", value)
+	}
+
+	resp, err := t.Next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
+	// If the transport removed the content coding, the body is the representation, so
+	// only Repr-Digest can be checked. Without a content coding, either can be.
+	header := "Content-Digest"
+	if resp.Uncompressed || (resp.Header.Get("Content-Digest") == "" && resp.Header.Get("Content-Encoding") == "") {
+		header = "Repr-Digest"
+	}
+	digests, err := parseDigestHeader(resp.Header.Values(header))
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("invalid %s header: %v", header, err)
+	}
+	if len(digests) > 0 && resp.Body != nil {
+		resp.Body = newDigestVerifier(resp.Body, header, digests)
+	}
+	return resp, nil
+}
+
+// VerifyContentDigest is middleware that checks the Content-Digest header of incoming
+// requests, if there is one, against the request body. It reads the whole body before
+// calling next, so that a handler such as JSONHandler never decodes a body that does
+// not match. Requests with a mismatched digest, or a digest using only unsupported
+// algorithms, fail with a public 400 error.
+//
+// e.g.
+//
+//   mux.Use(VerifyContentDigest)
+//
+func VerifyContentDigest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyRequestDigest(r); err != nil {
+			httperr.ReportError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func verifyRequestDigest(r *http.Request) error {
+	values := r.Header.Values("Content-Digest")
+	if len(values) == 0 {
+		return nil
+	}
+	digests, err := parseDigestHeader(values)
+	if err != nil {
+		return httperr.Publicf(http.StatusBadRequest, "invalid Content-Digest header: %v", err)
+	}
+	if len(digests) == 0 {
+		return httperr.Publicf(http.StatusBadRequest, "Content-Digest header does not use a supported algorithm")
+	}
+
+	body, err := readBody(&r.Body)
+	if err != nil {
+		return err
+	}
+	for _, alg := range sortedKeys(digests) {
+		h := newDigestHash(alg)
+		h.Write(body)
+		if !bytes.Equal(h.Sum(nil), digests[alg]) {
+			return httperr.Publicf(http.StatusBadRequest, "Content-Digest %s does not match the request body", alg)
+		}
+	}
+	return nil
+}
+
+// digestHeader returns the value of a Content-Digest or Repr-Digest header for body.
+func digestHeader(algorithms []string, body []byte) (string, error) {
+	values := make([]string, len(algorithms))
+	for i, alg := range algorithms {
+		h := newDigestHash(alg)
+		if h == nil {
+			return "", fmt.Errorf("unsupported digest algorithm %s", alg)
+		}
+		h.Write(body)
+		values[i] = alg + "=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":"
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// parseDigestHeader returns the digests in a Content-Digest or Repr-Digest header, by
+// algorithm. Unsupported algorithms are ignored.
+func parseDigestHeader(values []string) (map[string][]byte, error) {
+	members, err := parseDictionary(strings.Join(values, ", "))
+	if err != nil {
+		return nil, err
+	}
+	digests := map[string][]byte{}
+	for alg, value := range members {
+		if newDigestHash(alg) == nil {
+			continue
+		}
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("%s digest is not a byte sequence", alg)
+		}
+		digest, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%s digest is not valid base64", alg)
+		}
+		digests[alg] = digest
+	}
+	return digests, nil
+}
+
+func newDigestHash(alg string) hash.Hash {
+	switch alg {
+	case DigestAlgorithmSHA256:
+		return sha256.New()
+	case DigestAlgorithmSHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// digestVerifier is a response body that checks digests of the body once it has been
+// read to the end.
+type digestVerifier struct {
+	io.ReadCloser
+	header  string
+	digests map[string][]byte
+	hashes  map[string]hash.Hash
+}
+
+func newDigestVerifier(body io.ReadCloser, header string, digests map[string][]byte) *digestVerifier {
+	v := &digestVerifier{ReadCloser: body, header: header, digests: digests, hashes: map[string]hash.Hash{}}
+	for alg := range digests {
+		v.hashes[alg] = newDigestHash(alg)
+	}
+	return v
+}
+
+func (v *digestVerifier) Read(buf []byte) (int, error) {
+	n, err := v.ReadCloser.Read(buf)
+	for _, h := range v.hashes {
+		h.Write(buf[:n])
+	}
+	if err == io.EOF {
+		for _, alg := range sortedKeys(v.digests) {
+			if !bytes.Equal(v.hashes[alg].Sum(nil), v.digests[alg]) {
+				return n, fmt.Errorf("%s %s does not match the response body", v.header, alg)
+			}
+		}
+	}
+	return n, err
+}
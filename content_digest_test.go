@@ -0,0 +1,127 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestContentDigestTransport(t *testing.T) {
+	// ref: https://www.rfc-editor.org/rfc/rfc9530#appendix-D.1
+	const sha256Digest = "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:"
+	const sha512Digest = "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+
+	var responseDigest string
+	server := FakeServer(func(r *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		resp.Header.Set("Repr-Digest", responseDigest)
+		resp.Body = ioutil.NopCloser(strings.NewReader(r.Header.Get("Content-Digest")))
+		return resp, nil
+	})
+	transport := ContentDigestTransport{
+		Next:       server,
+		Algorithms: []string{DigestAlgorithmSHA256, DigestAlgorithmSHA512},
+	}
+	client := http.Client{Transport: transport}
+
+	responseDigest, _ = digestHeader([]string{DigestAlgorithmSHA256}, []byte(sha256Digest+", "+sha512Digest))
+	resp, err := client.Post("https://api.example.com/foo", "application/json", strings.NewReader(`{"hello": "world"}`))
+	assert.NilError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	assert.Equal(t, sha256Digest+", "+sha512Digest, string(body))
+
+	responseDigest = sha256Digest
+	resp, err = client.Post("https://api.example.com/foo", "application/json", strings.NewReader(`{"hello": "world"}`))
+	assert.NilError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.Check(t, is.ErrorContains(err, "Repr-Digest sha-256 does not match the response body"))
+
+	// the caller's request is not modified
+	r, err := http.NewRequest("POST", "https://api.example.com/foo", strings.NewReader(`{"hello": "world"}`))
+	assert.NilError(t, err)
+	reqBody := r.Body
+	_, err = transport.RoundTrip(r)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("", r.Header.Get("Content-Digest")))
+	assert.Check(t, r.Body == reqBody)
+}
+
+func TestContentDigestTransportNoBody(t *testing.T) {
+	var status int
+	transport := ContentDigestTransport{Next: FakeServer(func(r *http.Request) (*http.Response, error) {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}, Body: http.NoBody}
+		resp.Header.Set("Content-Digest", "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+		return resp, nil
+	})}
+	client := http.Client{Transport: transport}
+
+	for _, tc := range []struct {
+		Method string
+		Status int
+	}{
+		{"HEAD", http.StatusOK},
+		{"GET", http.StatusNoContent},
+		{"GET", http.StatusNotModified},
+	} {
+		status = tc.Status
+		r, err := http.NewRequest(tc.Method, "https://api.example.com/foo", nil)
+		assert.NilError(t, err)
+		resp, err := client.Do(r)
+		assert.NilError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.Check(t, err, "%s %d", tc.Method, tc.Status)
+	}
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	handler := VerifyContentDigest(JSONHandlerFunc(func(r *http.Request, in struct{ Hello string }) (*struct{ Hello string }, error) {
+		return &in, nil
+	}))
+	client := http.Client{Transport: HandlerTransport{Handler: handler}}
+
+	post := func(t *testing.T, digest string) *http.Response {
+		r, err := http.NewRequest("POST", "https://api.example.com/foo", strings.NewReader(`{"hello": "world"}`))
+		assert.NilError(t, err)
+		if digest != "" {
+			r.Header.Set("Content-Digest", digest)
+		}
+		resp, err := client.Do(r)
+		assert.NilError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, post(t, "").StatusCode)
+	assert.Equal(t, http.StatusOK, post(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:").StatusCode)
+	assert.Equal(t, http.StatusOK, post(t, "md5=:AAAA:, sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:").StatusCode)
+
+	resp := post(t, "sha-256=:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=:")
+	assert.Check(t, is.Equal(http.StatusBadRequest, resp.StatusCode))
+	assert.Check(t, is.Equal("Content-Digest sha-256 does not match the request body", resp.Header.Get("X-Error-Message")))
+
+	resp = post(t, "md5=:AAAA:")
+	assert.Check(t, is.Equal(http.StatusBadRequest, resp.StatusCode))
+	assert.Check(t, is.Equal("Content-Digest header does not use a supported algorithm", resp.Header.Get("X-Error-Message")))
+
+	resp = post(t, "sha-256=X48E")
+	assert.Check(t, is.Equal(http.StatusBadRequest, resp.StatusCode))
+	assert.Check(t, is.Equal("invalid Content-Digest header: sha-256 digest is not a byte sequence", resp.Header.Get("X-Error-Message")))
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// ETagger is implemented by output types of JSONHandler that know their own entity tag,
+// e.g. a version number from the database, so that it need not be computed from the
+// encoded output.
+type ETagger interface {
+	// ETag returns the entity tag. If it is not quoted, it is quoted to make a strong
+	// entity tag, e.g. `v42` becomes `"v42"`.
+	ETag() string
+}
+
+type conditionalJSONIndexType int
+
+const conditionalJSONIndex conditionalJSONIndexType = iota
+
+// ConditionalJSON is middleware that enables conditional requests for the JSONHandlers
+// that it wraps. The input and output types of a wrapped JSONHandler are preserved for
+// Registry.Handle.
+//
+// Responses with output have an ETag header, taken from the output if it implements
+// ETagger, and otherwise a strong entity tag computed from the encoded output. GET and
+// HEAD requests with an If-None-Match header that matches it are answered with 304 Not
+// Modified and no body.
+//
+// Handlers can use ETag, CheckIfMatch and RequireIfMatch to implement optimistic
+// concurrency control for requests that modify resources.
+//
+// e.g.
+//
+//   mux.Handle(pat.Get("/widgets/:id"), ConditionalJSON(JSONHandlerFunc(getWidget)))
+//
+//   func putWidget(r *http.Request, in PutWidgetInput) (*Widget, error) {
+//      widget, err := loadWidget(r.Context(), in.ID)
+//      if err != nil {
+//         return nil, err
+//      }
+//      etag, err := ETag(widget)
+//      if err != nil {
+//         return nil, err
+//      }
+//      if err := RequireIfMatch(r, etag); err != nil {
+//         return nil, err
+//      }
+//      /* update the widget */
+//   }
+//
+func ConditionalJSON(next http.Handler) http.Handler {
+	return conditionalJSONHandler{next: next}
+}
+
+// conditionalJSONHandler is the http.Handler returned by ConditionalJSON.
+type conditionalJSONHandler struct {
+	next http.Handler
+}
+
+func (h conditionalJSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), conditionalJSONIndex, true)
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (h conditionalJSONHandler) jsonTypes() (in, out reflect.Type) {
+	if jt, ok := h.next.(jsonTyper); ok {
+		return jt.jsonTypes()
+	}
+	return nil, nil
+}
+
+func conditionalJSONEnabled(r *http.Request) bool {
+	enabled, _ := r.Context().Value(conditionalJSONIndex).(bool)
+	return enabled
+}
+
+// ETag returns the entity tag that JSONHandler sends for the output v when conditional
+// requests are enabled with ConditionalJSON.
+func ETag(v interface{}) (string, error) {
+	if etagger, ok := asETagger(v); ok {
+		return quoteETag(etagger.ETag()), nil
+	}
+	body, err := encodeJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return etagOf(v, body), nil
+}
+
+// etagOf returns the entity tag of v, whose encoding is body.
+func etagOf(v interface{}, body []byte) string {
+	if etagger, ok := asETagger(v); ok {
+		return quoteETag(etagger.ETag())
+	}
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// asETagger returns v as an ETagger, unless v is a nil pointer, which is encoded as null
+// and whose ETag method may not handle nil.
+func asETagger(v interface{}) (ETagger, bool) {
+	etagger, ok := v.(ETagger)
+	if !ok {
+		return nil, false
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, false
+	}
+	return etagger, true
+}
+
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// CheckIfMatch returns httperr.PreconditionFailed if r has an If-Match header that does
+// not match etag, the current entity tag of the resource. Requests without If-Match are
+// allowed.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7232#section-3.1
+func CheckIfMatch(r *http.Request, etag string) error {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return nil
+	}
+	if !etagListMatches(values, etag, true) {
+		return httperr.PreconditionFailed
+	}
+	return nil
+}
+
+// RequireIfMatch is like CheckIfMatch, but returns httperr.PreconditionRequired if r does
+// not have an If-Match header.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc6585#section-3
+func RequireIfMatch(r *http.Request, etag string) error {
+	if len(r.Header.Values("If-Match")) == 0 {
+		return httperr.PreconditionRequired
+	}
+	return CheckIfMatch(r, etag)
+}
+
+// etagListMatches returns true if the values of an If-Match or If-None-Match header
+// contain etag, or are "*". If strong is set, weak entity tags never match, as required
+// for If-Match; otherwise entity tags are compared weakly, as for If-None-Match.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7232#section-2.3.2
+func etagListMatches(values []string, etag string, strong bool) bool {
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, value := range values {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			switch {
+			case candidate == "*":
+				return true
+			case strong && strings.HasPrefix(candidate, "W/"):
+				continue
+			case strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/"):
+				return true
+			}
+		}
+	}
+	return false
+}
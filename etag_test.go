@@ -0,0 +1,196 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goji.io"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+type versionedWidget struct {
+	Name    string
+	Version string `json:"-"`
+}
+
+func (w versionedWidget) ETag() string { return w.Version }
+
+func TestConditionalJSON(t *testing.T) {
+	type Widget struct {
+		Name string
+	}
+	h := ConditionalJSON(JSONOutputHandlerFunc(func(r *http.Request) (*Widget, error) {
+		return &Widget{Name: "frob"}, nil
+	}))
+	etag, err := ETag(&Widget{Name: "frob"})
+	assert.NilError(t, err)
+
+	t.Run("sets etag", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Equal(t, "application/json", w.Header().Get("Content-type"))
+		assert.Equal(t, `{"Name":"frob"}`+"\n", w.Body.String())
+	})
+
+	t.Run("if-none-match matches", func(t *testing.T) {
+		for _, value := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("If-None-Match", value)
+			h.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusNotModified, w.Code, value)
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			assert.Equal(t, "", w.Body.String())
+		}
+	})
+
+	t.Run("if-none-match does not match", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", `"other"`)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"Name":"frob"}`+"\n", w.Body.String())
+	})
+
+	t.Run("if-none-match ignored for post", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("If-None-Match", etag)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", etag)
+		JSONOutputHandlerFunc(func(r *http.Request) (*Widget, error) {
+			return &Widget{Name: "frob"}, nil
+		}).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "", w.Header().Get("ETag"))
+	})
+
+	t.Run("etagger", func(t *testing.T) {
+		h := ConditionalJSON(JSONOutputHandlerFunc(func(r *http.Request) (*versionedWidget, error) {
+			return &versionedWidget{Name: "frob", Version: "v42"}, nil
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, `"v42"`, w.Header().Get("ETag"))
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", `"v42"`)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+}
+
+func TestConditionalJSONNilOutput(t *testing.T) {
+	h := ConditionalJSON(JSONOutputHandlerFunc(func(r *http.Request) (*versionedWidget, error) {
+		return nil, nil
+	}))
+	etag, err := ETag((*versionedWidget)(nil))
+	assert.NilError(t, err)
+	nullETag, err := ETag(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, nullETag, etag)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "null\n", w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+}
+
+func TestConditionalJSONRegistry(t *testing.T) {
+	mux := goji.NewMux()
+	api := &Registry{}
+	api.Handle(mux, Operation{Method: "PUT", Pattern: "/widgets/:id"},
+		ConditionalJSON(JSONHandlerFunc(func(r *http.Request, in UpdateWidgetInput) (*Widget, error) {
+			return &in.Widget, nil
+		})))
+
+	ops := api.Operations()
+	assert.Assert(t, is.Len(ops, 1))
+	assert.Check(t, is.Equal(typeOf[UpdateWidgetInput](), ops[0].Input))
+	assert.Check(t, is.Equal(typeOf[Widget](), ops[0].Output))
+
+	operation := api.Document()["paths"].(map[string]interface{})["/widgets/{id}"].(map[string]interface{})["put"].(map[string]interface{})
+	assert.Check(t, is.Contains(operation, "requestBody"))
+	assert.Check(t, is.Contains(operation["responses"], "200"))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("PUT", "/widgets/w1", strings.NewReader(`{"name": "frob", "color": "red"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Check(t, w.Header().Get("ETag") != "")
+}
+
+func TestIfMatch(t *testing.T) {
+	etag, err := ETag(versionedWidget{Version: "v42"})
+	assert.NilError(t, err)
+	assert.Equal(t, `"v42"`, etag)
+
+	h := ConditionalJSON(JSONHandlerFunc(func(r *http.Request, in versionedWidget) (*versionedWidget, error) {
+		if err := RequireIfMatch(r, etag); err != nil {
+			return nil, err
+		}
+		return &versionedWidget{Name: in.Name, Version: "v43"}, nil
+	}))
+
+	for _, tc := range []struct {
+		Name    string
+		IfMatch string
+		Status  int
+	}{
+		{"missing", "", http.StatusPreconditionRequired},
+		{"matches", `"v42"`, http.StatusOK},
+		{"matches list", `"v41", "v42"`, http.StatusOK},
+		{"matches any", "*", http.StatusOK},
+		{"stale", `"v41"`, http.StatusPreconditionFailed},
+		{"weak", `W/"v42"`, http.StatusPreconditionFailed},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("PUT", "/", strings.NewReader(`{"Name": "frob"}`))
+			if tc.IfMatch != "" {
+				r.Header.Set("If-Match", tc.IfMatch)
+			}
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tc.Status, w.Code)
+			if tc.Status == http.StatusOK {
+				assert.Equal(t, `"v43"`, w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	t.Run("check allows missing", func(t *testing.T) {
+		r := httptest.NewRequest("PUT", "/", nil)
+		assert.NilError(t, CheckIfMatch(r, etag))
+		r.Header.Set("If-Match", `"v41"`)
+		assert.Equal(t, httperr.PreconditionFailed, CheckIfMatch(r, etag))
+	})
+}
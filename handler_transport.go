@@ -0,0 +1,187 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// defaultRemoteAddr is the client address seen by handlers invoked by HandlerTransport. Like
+// httptest.NewRequest, it is in the TEST-NET-1 range.
+const defaultRemoteAddr = "192.0.2.1:1234"
+
+// HandlerTransport is an http.RoundTripper that serves requests by invoking Handler in-process,
+// without opening a socket. This lets client and server code, e.g. a JSONClient and a goji mux
+// of JSONHandlers, be tested together.
+//
+// The handler receives a request that looks like one received by net/http's server: the URL
+// holds only the path and query, RequestURI and Host are set, RemoteAddr is set to RemoteAddr
+// (or 192.0.2.1:1234), and TLS is set for https URLs. The response body is streamed as the
+// handler writes it, and cancelling the request context cancels the handler's context.
+//
+// e.g.
+//
+//   mux := goji.NewMux()
+//   mux.Handle(pat.Post("/foo"), JSONHandlerFunc(foo))
+//   c := JSONClient{Client: &http.Client{Transport: HandlerTransport{Handler: mux}}}
+//   err := c.DoJSON(ctx, "POST", "https://api.example.com/foo", req, &resp)
+//
+type HandlerTransport struct {
+	Handler    http.Handler
+	RemoteAddr string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t HandlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+
+	req := r.Clone(ctx)
+	req.URL = &url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	req.RequestURI = r.URL.RequestURI()
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.1", 1, 1
+	if req.Host == "" {
+		req.Host = r.URL.Host
+	}
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	req.RemoteAddr = t.RemoteAddr
+	if req.RemoteAddr == "" {
+		req.RemoteAddr = defaultRemoteAddr
+	}
+	if r.URL.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{
+			Version:           tls.VersionTLS13,
+			HandshakeComplete: true,
+			ServerName:        r.URL.Hostname(),
+		}
+	}
+
+	pr, pw := io.Pipe()
+	w := &pipeResponseWriter{
+		header:  http.Header{},
+		body:    pw,
+		isHead:  r.Method == http.MethodHead,
+		request: r,
+		ready:   make(chan *http.Response, 1),
+	}
+	w.response = &http.Response{Body: pr}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				err := fmt.Errorf("handler panic: %v", p)
+				w.fail(err)
+				pw.CloseWithError(err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			pw.Close()
+		}()
+		t.Handler.ServeHTTP(w, req)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	select {
+	case resp := <-w.ready:
+		if resp == nil {
+			return nil, w.err
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pipeResponseWriter is the http.ResponseWriter given to handlers by HandlerTransport. The
+// response is sent on ready when the header is written, and the body is written to a pipe.
+type pipeResponseWriter struct {
+	header  http.Header
+	body    *io.PipeWriter
+	isHead  bool
+	request *http.Request
+	ready   chan *http.Response
+
+	mu          sync.Mutex
+	wroteHeader bool
+	response    *http.Response
+	err         error
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	resp := w.response
+	resp.Status = fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	resp.StatusCode = statusCode
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	resp.Header = w.header.Clone()
+	resp.ContentLength = -1
+	if cl, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		resp.ContentLength = cl
+	}
+	resp.Request = w.request
+	w.ready <- resp
+}
+
+func (w *pipeResponseWriter) Write(buf []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.isHead {
+		return len(buf), nil
+	}
+	return w.body.Write(buf)
+}
+
+// Flush implements http.Flusher. Writes are unbuffered, so Flush only ensures that the
+// header has been sent.
+func (w *pipeResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// fail reports err to RoundTrip if the header has not been sent yet.
+func (w *pipeResponseWriter) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.err = err
+	w.ready <- nil
+}
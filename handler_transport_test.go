@@ -0,0 +1,111 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+func TestHandlerTransport(t *testing.T) {
+	t.Run("json handler", func(t *testing.T) {
+		mux := goji.NewMux()
+		mux.Handle(pat.Post("/foo/:id"), JSONHandlerFunc(func(r *http.Request, in RequestBody) (*ResponseBody, error) {
+			assert.Check(t, is.Equal("192.0.2.1:1234", r.RemoteAddr))
+			assert.Check(t, is.Equal("api.example.com", r.Host))
+			assert.Check(t, is.Equal("/foo/frob?x=1", r.RequestURI))
+			assert.Check(t, is.Equal("", r.URL.Host))
+			assert.Check(t, r.TLS != nil)
+			if in.Foo == "" {
+				return nil, httperr.Publicf(http.StatusBadRequest, "foo is required")
+			}
+			return &ResponseBody{Bar: in.Foo + pat.Param(r, "id")}, nil
+		}))
+
+		c := JSONClient{Client: &http.Client{Transport: HandlerTransport{Handler: mux}}, OnError: httperr.FromResponse}
+
+		resp := ResponseBody{}
+		err := c.DoJSON(context.Background(), "POST", "https://api.example.com/foo/frob?x=1", RequestBody{Foo: "foo"}, &resp)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, resp, ResponseBody{Bar: "foofrob"})
+
+		err = c.DoJSON(context.Background(), "POST", "https://api.example.com/foo/frob?x=1", RequestBody{}, &resp)
+		assert.Check(t, is.Equal(http.StatusBadRequest, httperr.StatusCode(err)))
+		assert.Check(t, is.Error(err, "foo is required"))
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		next := make(chan struct{})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Check(t, is.Nil(r.TLS))
+			assert.Check(t, is.Equal("10.0.0.1:5555", r.RemoteAddr))
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintln(w, "first")
+			w.(http.Flusher).Flush()
+			<-next
+			fmt.Fprintln(w, "second")
+		})
+
+		client := http.Client{Transport: HandlerTransport{Handler: handler, RemoteAddr: "10.0.0.1:5555"}}
+		resp, err := client.Get("http://example.com/")
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+
+		body := bufio.NewReader(resp.Body)
+		line, err := body.ReadString('\n')
+		assert.NilError(t, err)
+		assert.Equal(t, "first\n", line)
+		close(next)
+		line, err = body.ReadString('\n')
+		assert.NilError(t, err)
+		assert.Equal(t, "second\n", line)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		handlerDone := make(chan error)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			<-r.Context().Done()
+			handlerDone <- r.Context().Err()
+		})
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+		_, err := HandlerTransport{Handler: handler}.RoundTrip(req)
+		assert.Check(t, is.ErrorContains(err, "context canceled"))
+		assert.Check(t, is.Equal(context.Canceled, <-handlerDone))
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("cannot frob the grob")
+		})
+
+		client := http.Client{Transport: HandlerTransport{Handler: handler}}
+		_, err := client.Get("http://example.com/")
+		assert.Check(t, is.ErrorContains(err, "handler panic: cannot frob the grob"))
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// Headers used by HMACSigningTransport and HMACVerifier.
+const (
+	HMACKeyIDHeader     = "X-Signature-Key-Id"
+	HMACTimestampHeader = "X-Signature-Timestamp"
+	HMACNonceHeader     = "X-Signature-Nonce"
+	HMACSignatureHeader = "X-Signature"
+)
+
+const defaultHMACMaxSkew = 5 * time.Minute
+
+// HMACSigningTransport is an http.RoundTripper that signs requests with HMAC-SHA256.
+//
+// The signature covers the method, the path and query, the time of the request, a random
+// nonce and the SHA-256 digest of the body. It is sent in the X-Signature header, along
+// with the X-Signature-Key-Id, X-Signature-Timestamp and X-Signature-Nonce headers.
+// Requests are verified on the server by HMACVerifier. The nonce makes each request
+// unique, so that identical requests in the same second, e.g. retries, are not rejected
+// as replays.
+//
+// The signature covers the URL that Next sends, so HMACSigningTransport should come
+// after any transport that changes it, e.g. URLPrefixTransport.
+//
+// e.g.
+//
+//   transport := HMACSigningTransport{
+//      Next:  http.DefaultTransport,
+//      KeyID: "2020-01",
+//      Key:   []byte("hunter2"),
+//   }
+//
+type HMACSigningTransport struct {
+	Next  http.RoundTripper
+	KeyID string
+	Key   []byte
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t HMACSigningTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	body, err := readBody(&r.Body)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	var nonce [16]byte
+	mustReadRandom(nonce[:])
+
+	r.Header.Set(HMACKeyIDHeader, t.KeyID)
+	r.Header.Set(HMACTimestampHeader, timestamp)
+	r.Header.Set(HMACNonceHeader, hex.EncodeToString(nonce[:]))
+	r.Header.Set(HMACSignatureHeader, hmacSignature(t.Key, r.Method, r.URL.RequestURI(), timestamp,
+		r.Header.Get(HMACNonceHeader), body))
+	return t.Next.RoundTrip(r)
+}
+
+// HMACVerifier is middleware that verifies requests signed by HMACSigningTransport.
+//
+// Keys maps key IDs to keys, so that a new key can be added before clients switch to
+// it and the old one removed after. Requests are rejected if their timestamp differs
+// from the current time by more than MaxSkew (5 minutes if zero), or if the same
+// nonce has already been seen within that window.
+//
+// Rejected requests fail with httperr.Unauthorized. The reason is reported to the
+// function given to httperr.OnError, but is not revealed to the client.
+//
+// e.g.
+//
+//   verifier := &HMACVerifier{Keys: map[string][]byte{
+//      "2020-01": []byte("hunter2"),
+//      "2020-02": []byte("correct horse battery staple"),
+//   }}
+//   mux.Use(verifier.Middleware)
+//
+type HMACVerifier struct {
+	Keys    map[string][]byte
+	MaxSkew time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // nonce -> when it can be forgotten
+	nextPrune time.Time
+}
+
+// Middleware returns a handler that verifies the signature of each request before
+// passing it to next.
+func (v *HMACVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.verify(r); err != nil {
+			httperr.ReportError(w, r, httperr.Wrap(http.StatusUnauthorized, err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *HMACVerifier) verify(r *http.Request) error {
+	maxSkew := v.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = defaultHMACMaxSkew
+	}
+
+	keyID := r.Header.Get(HMACKeyIDHeader)
+	timestamp := r.Header.Get(HMACTimestampHeader)
+	nonce := r.Header.Get(HMACNonceHeader)
+	signature := r.Header.Get(HMACSignatureHeader)
+	if keyID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return errors.New("request is not signed")
+	}
+
+	key, ok := v.Keys[keyID]
+	if !ok {
+		return errors.New("unknown signing key")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	now := time.Now()
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return errors.New("signature timestamp is outside the allowed window")
+	}
+
+	body, err := readBody(&r.Body)
+	if err != nil {
+		return err
+	}
+	want := hmacSignature(key, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return errors.New("signature does not match")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.seen == nil {
+		v.seen = map[string]time.Time{}
+	}
+	if now.After(v.nextPrune) {
+		for s, expiry := range v.seen {
+			if now.After(expiry) {
+				delete(v.seen, s)
+			}
+		}
+		v.nextPrune = now.Add(maxSkew)
+	}
+	if _, replayed := v.seen[nonce]; replayed {
+		return errors.New("nonce has already been used")
+	}
+	v.seen[nonce] = signedAt.Add(maxSkew)
+	return nil
+}
+
+// hmacSignature returns the hex encoded HMAC-SHA256 of a request.
+func hmacSignature(key []byte, method, requestURI, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+func TestHMAC(t *testing.T) {
+	verifier := &HMACVerifier{Keys: map[string][]byte{
+		"old": []byte("hunter2"),
+		"new": []byte("correct horse battery staple"),
+	}}
+	var reported error
+	handler := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Check(t, err)
+		_, _ = w.Write(body)
+	}))
+	server := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reported = nil
+		r = httperr.OnError(r, func(err error) {
+			reported = err
+			httperr.Write(w, r, err)
+		})
+		handler.ServeHTTP(w, r)
+	})
+
+	post := func(t *testing.T, transport http.RoundTripper, body string) *http.Response {
+		client := http.Client{Transport: transport}
+		resp, err := client.Post("https://api.example.com/hook?a=b", "text/plain", strings.NewReader(body))
+		assert.NilError(t, err)
+		return resp
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for _, keyID := range []string{"old", "new"} {
+			transport := HMACSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: keyID, Key: verifier.Keys[keyID]}
+			resp := post(t, transport, "hello "+keyID)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NilError(t, err)
+			assert.Equal(t, "hello "+keyID, string(body))
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		resp := post(t, HandlerTransport{Handler: server}, "hello")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Check(t, is.ErrorContains(reported, "request is not signed"))
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		transport := HMACSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: "other", Key: []byte("hunter2")}
+		resp := post(t, transport, "hello")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Check(t, is.ErrorContains(reported, "unknown signing key"))
+		assert.Equal(t, "", resp.Header.Get("X-Error-Message"))
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tamper := FakeServer(func(r *http.Request) (*http.Response, error) {
+			r.Body = ioutil.NopCloser(strings.NewReader("goodbye"))
+			return HandlerTransport{Handler: server}.RoundTrip(r)
+		})
+		transport := HMACSigningTransport{Next: tamper, KeyID: "new", Key: verifier.Keys["new"]}
+		resp := post(t, transport, "hello")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Check(t, is.ErrorContains(reported, "signature does not match"))
+	})
+
+	signed := func(at time.Time, nonce string) *http.Request {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		r := httptest.NewRequest("POST", "/hook", strings.NewReader("hello"))
+		r.Header.Set(HMACKeyIDHeader, "new")
+		r.Header.Set(HMACTimestampHeader, timestamp)
+		r.Header.Set(HMACNonceHeader, nonce)
+		r.Header.Set(HMACSignatureHeader, hmacSignature(verifier.Keys["new"], "POST", "/hook", timestamp, nonce, []byte("hello")))
+		return r
+	}
+
+	t.Run("clock skew", func(t *testing.T) {
+		for _, at := range []time.Time{time.Now().Add(-10 * time.Minute), time.Now().Add(10 * time.Minute)} {
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, signed(at, "skew-"+at.String()))
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Check(t, is.ErrorContains(reported, "outside the allowed window"))
+		}
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, signed(time.Now().Add(-time.Minute), "skew"))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		at := time.Now()
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, signed(at, "replay"))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		server.ServeHTTP(w, signed(at, "replay"))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Check(t, is.ErrorContains(reported, "nonce has already been used"))
+	})
+
+	t.Run("identical requests", func(t *testing.T) {
+		transport := HMACSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: "new", Key: verifier.Keys["new"]}
+		for i := 0; i < 3; i++ {
+			resp := post(t, transport, "hello")
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("does not modify request", func(t *testing.T) {
+		transport := HMACSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: "new", Key: verifier.Keys["new"]}
+		r, err := http.NewRequest("POST", "https://api.example.com/hook", strings.NewReader("hello"))
+		assert.NilError(t, err)
+		body := r.Body
+		resp, err := transport.RoundTrip(r)
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Check(t, is.Len(r.Header, 0))
+		assert.Check(t, r.Body == body)
+	})
+}
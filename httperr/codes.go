@@ -79,6 +79,9 @@ var (
 	// Teapot is an error that returns a generic status 418 error
 	Teapot = Code(418)
 
+	// PreconditionRequired is an error that returns a generic status 428 error
+	PreconditionRequired = Code(428)
+
 	// InternalServerError is an error that returns a generic status 500 error
 	InternalServerError = Code(500)
 
@@ -23,10 +23,48 @@ type onErrorIndexType int
 
 const onErrorIndex onErrorIndexType = iota
 
+// errorHook is the function stored in the request context by OnError and MapError. It is
+// called with the writer and request passed to ReportError.
+type errorHook func(w http.ResponseWriter, r *http.Request, err error)
+
 // OnError returns a new http.Request that holds a reference to a
 // function that will report an error when returned from a request.
 func OnError(r *http.Request, f func(err error)) *http.Request {
-	return r.WithContext(context.WithValue(r.Context(), onErrorIndex, f))
+	return withErrorHook(r, func(w http.ResponseWriter, r *http.Request, err error) {
+		f(err)
+	})
+}
+
+// MapError returns a new http.Request that passes each error reported by
+// ReportError to f, and then passes the error that f returns on to the
+// function given in an earlier call to OnError, or writes it with Write if
+// there is none. Unlike OnError, it lets middleware annotate errors without
+// taking over how they are reported.
+//
+// The error is written to the http.ResponseWriter given to ReportError, so
+// writers wrapped by handlers between the middleware and ReportError see it.
+func MapError(r *http.Request, f func(err error) error) *http.Request {
+	next, _ := r.Context().Value(onErrorIndex).(errorHook)
+	return withErrorHook(r, func(w http.ResponseWriter, r *http.Request, err error) {
+		err = f(err)
+		if next != nil {
+			next(w, r, err)
+		} else {
+			Write(w, r, err)
+		}
+	})
+}
+
+// ObserveError is like MapError, but f only observes each error.
+func ObserveError(r *http.Request, f func(err error)) *http.Request {
+	return MapError(r, func(err error) error {
+		f(err)
+		return err
+	})
+}
+
+func withErrorHook(r *http.Request, hook errorHook) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), onErrorIndex, hook))
 }
 
 // ReportError reports the error to the function given in
@@ -34,8 +72,8 @@ func OnError(r *http.Request, f func(err error)) *http.Request {
 func ReportError(w http.ResponseWriter, r *http.Request, err error) {
 	err = TranslateError(err)
 
-	if v := r.Context().Value(onErrorIndex); v != nil {
-		v.(func(error))(err)
+	if hook, ok := r.Context().Value(onErrorIndex).(errorHook); ok {
+		hook(w, r, err)
 	} else {
 		Write(w, r, err)
 	}
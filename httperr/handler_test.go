@@ -0,0 +1,72 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httperr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// statusWriter remembers the status written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func TestMapError(t *testing.T) {
+	var observed []error
+	handler := func(w http.ResponseWriter, r *http.Request) *statusWriter {
+		r = ObserveError(r, func(err error) { observed = append(observed, err) })
+		r = MapError(r, func(err error) error { return errors.Wrap(err, "mapped") })
+
+		sw := &statusWriter{ResponseWriter: w}
+		ReportError(sw, r, NotFound)
+		return sw
+	}
+
+	t.Run("write", func(t *testing.T) {
+		observed = nil
+		w := httptest.NewRecorder()
+		sw := handler(w, httptest.NewRequest("GET", "/", nil))
+		assert.Check(t, is.Equal(http.StatusNotFound, w.Code))
+		// a writer wrapped after the middleware sees the error response
+		assert.Check(t, is.Equal(http.StatusNotFound, sw.status))
+		assert.Assert(t, is.Len(observed, 1))
+		assert.Check(t, is.Error(observed[0], "mapped: Not Found"))
+	})
+
+	t.Run("on error", func(t *testing.T) {
+		observed = nil
+		var reported error
+		w := httptest.NewRecorder()
+		r := OnError(httptest.NewRequest("GET", "/", nil), func(err error) {
+			reported = err
+			w.WriteHeader(StatusCode(err))
+		})
+		handler(w, r)
+		assert.Check(t, is.Error(reported, "mapped: Not Found"))
+		assert.Check(t, is.Len(observed, 1))
+	})
+}
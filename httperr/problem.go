@@ -0,0 +1,191 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httperr
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemContentType is the media type of an RFC 7807 problem details document.
+const ProblemContentType = "application/problem+json"
+
+// RenderProblemJSON controls whether Write emits an RFC 7807 application/problem+json
+// body to clients that accept it. When false (the default), Write only emits the
+// plain-text status text and the X-Error-Message header.
+//
+// Note: this variable is not go-routine safe. You should probably set it from
+// an init() function or similar.
+var RenderProblemJSON = false
+
+// Problem is an RFC 7807 problem details object.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc7807
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+
+	// Extensions holds extension members, which are serialized alongside the
+	// standard members.
+	Extensions map[string]interface{}
+}
+
+// MarshalJSON implements json.Marshaler
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	}
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *Problem) UnmarshalJSON(buf []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return err
+	}
+	*p = Problem{}
+	for k, raw := range m {
+		var err error
+		switch k {
+		case "type":
+			err = json.Unmarshal(raw, &p.Type)
+		case "title":
+			err = json.Unmarshal(raw, &p.Title)
+		case "status":
+			err = json.Unmarshal(raw, &p.Status)
+		case "detail":
+			err = json.Unmarshal(raw, &p.Detail)
+		case "instance":
+			err = json.Unmarshal(raw, &p.Instance)
+		default:
+			var v interface{}
+			err = json.Unmarshal(raw, &v)
+			if p.Extensions == nil {
+				p.Extensions = map[string]interface{}{}
+			}
+			p.Extensions[k] = v
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProblemTyper is an interface for errors that provide the "type" member of
+// a problem details object. If not implemented, "about:blank" is used.
+type ProblemTyper interface {
+	ProblemType() string
+}
+
+// ProblemExtender is an interface for errors that provide extension members
+// of a problem details object. Extensions are only emitted for public errors.
+type ProblemExtender interface {
+	ProblemExtensions() map[string]interface{}
+}
+
+// NewProblem returns the problem details object describing err. If err is not
+// public, only the status and the generic title are included.
+func NewProblem(r *http.Request, err error) Problem {
+	statusCode := StatusCode(err)
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+	}
+	if r != nil && r.URL != nil {
+		p.Instance = r.URL.Path
+	}
+	if !IsPublic(err) {
+		return p
+	}
+
+	p.Detail = publicMessage(err)
+
+	var pt ProblemTyper
+	if errors.As(err, &pt) {
+		p.Type = pt.ProblemType()
+	}
+	var pe ProblemExtender
+	if errors.As(err, &pe) {
+		p.Extensions = pe.ProblemExtensions()
+	}
+	return p
+}
+
+// WriteProblem emits err to w as an application/problem+json document,
+// regardless of RenderProblemJSON or the request Accept header.
+func WriteProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := NewProblem(r, err)
+	if IsPublic(err) {
+		w.Header().Add("X-Error-Message", p.Detail)
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// acceptsProblemJSON returns true if the Accept header of r admits either
+// application/problem+json or application/json.
+func acceptsProblemJSON(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			if mediaType != ProblemContentType && mediaType != "application/json" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
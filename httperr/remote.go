@@ -0,0 +1,108 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httperr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// RemoteError is an error reconstructed from an HTTP error response, typically one emitted
+// by Write in another service. It preserves the status code, and if the remote service
+// revealed a public message, the message and its public-ness, so that errors propagate
+// across service hops.
+type RemoteError struct {
+	Status  int
+	Message string
+
+	// Problem is the problem details document from the response body, if there was one.
+	Problem *Problem
+}
+
+var _ StatusCoder = RemoteError{}
+var _ IsPublicer = RemoteError{}
+
+func (e RemoteError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.Status)
+}
+
+// StatusCode implements StatusCoder
+func (e RemoteError) StatusCode() int {
+	return e.Status
+}
+
+// IsPublic implements IsPublicer. A remote error is public if the remote service revealed
+// its message.
+func (e RemoteError) IsPublic() bool {
+	return e.Message != ""
+}
+
+// ProblemType implements ProblemTyper
+func (e RemoteError) ProblemType() string {
+	if e.Problem != nil && e.Problem.Type != "" {
+		return e.Problem.Type
+	}
+	return "about:blank"
+}
+
+// ProblemExtensions implements ProblemExtender
+func (e RemoteError) ProblemExtensions() map[string]interface{} {
+	if e.Problem == nil {
+		return nil
+	}
+	return e.Problem.Extensions
+}
+
+// FromResponse returns a RemoteError describing resp. The public message is taken from the
+// detail of an application/problem+json body if present, otherwise from the X-Error-Message
+// header. If the body is not a problem details document it is left unread.
+//
+// FromResponse can be assigned to httpx.JSONClient.OnError:
+//
+//   c := httpx.JSONClient{Client: http.DefaultClient, OnError: httperr.FromResponse}
+//
+func FromResponse(resp *http.Response) error {
+	e := RemoteError{
+		Status:  resp.StatusCode,
+		Message: resp.Header.Get("X-Error-Message"),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != ProblemContentType || resp.Body == nil {
+		return e
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+
+	var p Problem
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return e
+	}
+	e.Problem = &p
+	if p.Detail != "" {
+		e.Message = p.Detail
+	}
+	return e
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httperr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestFromResponse(t *testing.T) {
+	// X-Error-Message header
+	{
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		Write(w, r, WrapPublic(Wrap(418, fmt.Errorf("cannot frob the grob"))))
+
+		err := errors.Wrap(FromResponse(w.Result()), "calling frob service")
+		assert.Check(t, is.Equal(418, StatusCode(err)))
+		assert.Check(t, IsPublic(err))
+		assert.Check(t, is.Equal("calling frob service: cannot frob the grob", err.Error()))
+
+		// the error propagates to the next hop
+		w2 := httptest.NewRecorder()
+		Write(w2, r, err)
+		assert.Check(t, is.Equal(418, w2.Code))
+		assert.Check(t, is.Equal("cannot frob the grob", w2.Header().Get("X-Error-Message")))
+	}
+
+	// private
+	{
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		Write(w, r, Wrap(418, fmt.Errorf("cannot frob the grob")))
+
+		err := FromResponse(w.Result())
+		assert.Check(t, is.Equal(418, StatusCode(err)))
+		assert.Check(t, !IsPublic(err))
+		assert.Check(t, is.Equal("I'm a teapot", err.Error()))
+	}
+
+	// problem+json
+	{
+		RenderProblemJSON = true
+		defer func() { RenderProblemJSON = false }()
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		r.Header.Set("Accept", "application/json")
+		Write(w, r, WrapPublic(extendedError{}))
+
+		err := FromResponse(w.Result())
+		assert.Check(t, is.Equal(422, StatusCode(err)))
+		assert.Check(t, IsPublic(err))
+		assert.Check(t, is.Equal("cannot frob the grob", err.Error()))
+
+		p := NewProblem(r, err)
+		assert.Check(t, is.Equal("https://example.com/problems/frob", p.Type))
+		assert.Check(t, is.DeepEqual(map[string]interface{}{"grob": "frob"}, p.Extensions))
+	}
+
+	// body is preserved when it is not a problem
+	{
+		resp := &http.Response{StatusCode: 502, Header: http.Header{}}
+		resp.Header.Set("Content-Type", "text/html")
+		resp.Body = ioutil.NopCloser(strings.NewReader("<h1>Bad Gateway</h1>"))
+		err := FromResponse(resp)
+		assert.Check(t, is.Equal(502, StatusCode(err)))
+		assert.Check(t, !IsPublic(err))
+
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.Check(t, err)
+		assert.Check(t, is.Equal("<h1>Bad Gateway</h1>", string(body)))
+	}
+}
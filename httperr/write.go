@@ -31,26 +31,61 @@ type ResponseWriter interface {
 // implements StatusCoder, then it is used to set the status code. If err implements IsPublicer, and IsPublic() returns
 // true, then the text of the error is included in the response, otherwise a generic message, e.g. "Bad Request" is
 // included in the response.
+//
+// If RenderProblemJSON is true and the request accepts JSON, the response body is an RFC 7807
+// application/problem+json document instead. See WriteProblem.
+//
+// If w reports that the response has already been committed, e.g. because it was wrapped
+// with httpx.WrapResponseWriter and the header has been sent, nothing is written.
 func Write(w http.ResponseWriter, r *http.Request, err error) {
+	if isCommitted(w) {
+		return
+	}
+
 	var rw ResponseWriter
 	if errors.As(err, &rw) {
 		rw.WriteResponse(w, r)
 		return
 	}
 
+	if RenderProblemJSON && acceptsProblemJSON(r) {
+		WriteProblem(w, r, err)
+		return
+	}
+
 	statusCode := StatusCode(err)
 	if statusCode == 0 {
 		statusCode = http.StatusInternalServerError
 	}
 
 	if IsPublic(err) {
-		errText := err.Error()
+		w.Header().Add("X-Error-Message", publicMessage(err))
+	}
+	http.Error(w, http.StatusText(statusCode), statusCode)
+}
 
-		if rootErr := pkgerrors.Cause(err); rootErr != nil {
-			errText = rootErr.Error()
-		}
+// publicMessage returns the text of err that is revealed in http responses.
+func publicMessage(err error) string {
+	errText := err.Error()
 
-		w.Header().Add("X-Error-Message", errText)
+	if rootErr := pkgerrors.Cause(err); rootErr != nil {
+		errText = rootErr.Error()
 	}
-	http.Error(w, http.StatusText(statusCode), statusCode)
+	return errText
+}
+
+// isCommitted returns true if w, or a writer that it wraps, has a Committed method that
+// returns true.
+func isCommitted(w http.ResponseWriter) bool {
+	for w != nil {
+		if c, ok := w.(interface{ Committed() bool }); ok && c.Committed() {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+	return false
 }
@@ -15,6 +15,7 @@
 package httperr
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -67,4 +68,104 @@ func TestWrite(t *testing.T) {
 		assert.Check(t, is.Equal(999, w.Code))
 		assert.Check(t, is.Equal("Hello, world!\n", string(w.Body.Bytes())))
 	}
+
+	// committed
+	{
+		w := httptest.NewRecorder()
+		w.WriteHeader(200)
+		r, _ := http.NewRequest("GET", "/", nil)
+		for _, cw := range []http.ResponseWriter{committedWriter{w}, wrappingWriter{committedWriter{w}}} {
+			Write(cw, r, WrapPublic(Wrap(418, fmt.Errorf("cannot frob the grob"))))
+			Write(cw, r, differentResponseWriter{})
+		}
+		assert.Check(t, is.Equal(200, w.Code))
+		assert.Check(t, is.Equal("", w.Header().Get("X-Error-Message")))
+		assert.Check(t, is.Equal("", string(w.Body.Bytes())))
+	}
+}
+
+// committedWriter is an http.ResponseWriter whose response has been committed.
+type committedWriter struct {
+	http.ResponseWriter
+}
+
+func (committedWriter) Committed() bool { return true }
+
+// wrappingWriter is an http.ResponseWriter that wraps another.
+type wrappingWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrappingWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+type extendedError struct{}
+
+func (extendedError) Error() string { return "cannot frob the grob" }
+
+func (extendedError) StatusCode() int { return 422 }
+
+func (extendedError) ProblemType() string { return "https://example.com/problems/frob" }
+
+func (extendedError) ProblemExtensions() map[string]interface{} {
+	return map[string]interface{}{"grob": "frob"}
+}
+
+func TestWriteProblem(t *testing.T) {
+	RenderProblemJSON = true
+	defer func() { RenderProblemJSON = false }()
+
+	// private
+	{
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		r.Header.Set("Accept", "application/json")
+		err := Wrap(418, fmt.Errorf("cannot frob the grob"))
+		Write(w, r, err)
+		assert.Check(t, is.Equal(418, w.Code))
+		assert.Check(t, is.Equal(ProblemContentType, w.Header().Get("Content-Type")))
+		assert.Check(t, is.Equal("", w.Header().Get("X-Error-Message")))
+		assert.Check(t, is.Equal(`{"instance":"/frob","status":418,"title":"I'm a teapot","type":"about:blank"}`+"\n", w.Body.String()))
+	}
+
+	// public
+	{
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		r.Header.Set("Accept", "application/problem+json")
+		err := WrapPublic(Wrap(418, fmt.Errorf("cannot frob the grob")))
+		Write(w, r, err)
+		assert.Check(t, is.Equal(418, w.Code))
+		assert.Check(t, is.Equal("cannot frob the grob", w.Header().Get("X-Error-Message")))
+		assert.Check(t, is.Equal(`{"detail":"cannot frob the grob","instance":"/frob","status":418,"title":"I'm a teapot","type":"about:blank"}`+"\n", w.Body.String()))
+	}
+
+	// public with type and extensions
+	{
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		r.Header.Set("Accept", "application/json")
+		Write(w, r, WrapPublic(extendedError{}))
+		assert.Check(t, is.Equal(422, w.Code))
+
+		var p Problem
+		assert.Check(t, json.Unmarshal(w.Body.Bytes(), &p))
+		assert.Check(t, is.DeepEqual(Problem{
+			Type:       "https://example.com/problems/frob",
+			Title:      "Unprocessable Entity",
+			Status:     422,
+			Detail:     "cannot frob the grob",
+			Instance:   "/frob",
+			Extensions: map[string]interface{}{"grob": "frob"},
+		}, p))
+	}
+
+	// not accepted
+	for _, accept := range []string{"", "text/html, */*", "application/json;q=0"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/frob", nil)
+		r.Header.Set("Accept", accept)
+		Write(w, r, WrapPublic(Wrap(418, fmt.Errorf("cannot frob the grob"))))
+		assert.Check(t, is.Equal(418, w.Code))
+		assert.Check(t, is.Equal("I'm a teapot\n", w.Body.String()), "accept: %q", accept)
+	}
 }
@@ -0,0 +1,44 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpxtest provides http.RoundTripper fakes for testing code that uses
+// httpx.JSONClient and the httpx transports.
+package httpxtest
+
+import (
+	"net/http"
+)
+
+// FakeServer is an http.RoundTripper that invokes a function to produce each response.
+//
+// Example:
+//
+//   c := httpx.JSONClient{Client: &http.Client{
+//      Transport: httpxtest.FakeServer(func(r *http.Request) (*http.Response, error) {
+//         return httpxtest.JSONResponse(r, http.StatusOK, map[string]string{"Bar": "baz"})
+//      }),
+//   }}
+//
+type FakeServer func(r *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f FakeServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// TestingT is the subset of testing.TB used by this package.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
@@ -0,0 +1,229 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpxtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"goji.io/pat"
+	"goji.io/pattern"
+)
+
+// MockTransport is an http.RoundTripper that answers requests according to a list of
+// expectations. Requests that do not match an expectation fail with an error, and are
+// reported by Verify along with any expectations that were not met.
+//
+// Example:
+//
+//   mock := &httpxtest.MockTransport{}
+//   mock.Expect("GET", "/users/:id").RespondJSON(http.StatusOK, User{Name: "alice"})
+//   mock.Expect("DELETE", "/users/:id").Respond(http.StatusNoContent, nil).Times(2)
+//
+//   c := httpx.JSONClient{Client: &http.Client{
+//      Transport: httpx.URLPrefixTransport{Next: mock, Server: "https://api.example.com"},
+//   }}
+//   // ...
+//   mock.Verify(t)
+//
+type MockTransport struct {
+	// Ordered, if true, requires requests to arrive in the order the expectations were added.
+	Ordered bool
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	unexpected   []string
+}
+
+// Expectation describes a request expected by a MockTransport and how to respond to it.
+type Expectation struct {
+	method  string
+	pattern *pat.Pattern
+	times   int
+	respond func(r *http.Request) (*http.Response, error)
+
+	mu    sync.Mutex
+	calls int
+}
+
+// Expect adds an expectation of a request with the given method whose URL path matches
+// path, a goji pat pattern such as "/users/:id". Path variables can be read by
+// responders with pat.Param. By default the request is expected exactly once, and is
+// answered with an empty 200 response.
+func (m *MockTransport) Expect(method string, path string) *Expectation {
+	e := &Expectation{
+		method:  method,
+		pattern: pat.New(path),
+		times:   1,
+		respond: func(r *http.Request) (*http.Response, error) {
+			return Response(r, http.StatusOK, nil), nil
+		},
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// Times sets the number of times the request is expected. If n is negative, the
+// request may be made any number of times, including zero.
+func (e *Expectation) Times(n int) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.times = n
+	return e
+}
+
+// RespondWith sets a function that produces the response.
+func (e *Expectation) RespondWith(f func(r *http.Request) (*http.Response, error)) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.respond = f
+	return e
+}
+
+// Respond sets the status code and body of the response.
+func (e *Expectation) Respond(statusCode int, body []byte) *Expectation {
+	return e.RespondWith(func(r *http.Request) (*http.Response, error) {
+		return Response(r, statusCode, body), nil
+	})
+}
+
+// RespondJSON sets the status code of the response, and a value that is serialized as
+// the JSON response body.
+func (e *Expectation) RespondJSON(statusCode int, body interface{}) *Expectation {
+	return e.RespondWith(func(r *http.Request) (*http.Response, error) {
+		return JSONResponse(r, statusCode, body)
+	})
+}
+
+// Calls returns the number of requests that matched the expectation.
+func (e *Expectation) Calls() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.pattern.String()
+}
+
+// matches returns the request with goji path variables set if r matches e.
+func (e *Expectation) matches(r *http.Request) *http.Request {
+	if r.Method != e.method {
+		return nil
+	}
+	return e.pattern.Match(r.WithContext(pattern.SetPath(r.Context(), r.URL.EscapedPath())))
+}
+
+// exhausted returns true if e cannot accept any more calls.
+func (e *Expectation) exhausted() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.times >= 0 && e.calls >= e.times
+}
+
+// satisfied returns true if e has been called as many times as required.
+func (e *Expectation) satisfied() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.times < 0 || e.calls >= e.times
+}
+
+// RoundTrip implements http.RoundTripper.
+func (m *MockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	m.mu.Lock()
+	var match *Expectation
+	var matched *http.Request
+	for _, e := range m.expectations {
+		if e.exhausted() {
+			continue
+		}
+		if matched = e.matches(r); matched != nil {
+			match = e
+			break
+		}
+		if m.Ordered && !e.satisfied() {
+			break
+		}
+	}
+	if match == nil {
+		call := r.Method + " " + r.URL.String()
+		m.unexpected = append(m.unexpected, call)
+		m.mu.Unlock()
+		return nil, fmt.Errorf("httpxtest: unexpected request %s", call)
+	}
+
+	// the call is counted before m.mu is released, so that concurrent requests cannot
+	// exceed the expected number of calls
+	match.mu.Lock()
+	match.calls++
+	respond := match.respond
+	match.mu.Unlock()
+	m.mu.Unlock()
+
+	return respond(matched)
+}
+
+// Verify reports an error to t for each expectation that was not met and each request
+// that did not match an expectation.
+func (m *MockTransport) Verify(t TestingT) {
+	t.Helper()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expectations {
+		if !e.satisfied() {
+			e.mu.Lock()
+			t.Errorf("expected %s %d time(s), got %d", e, e.times, e.calls)
+			e.mu.Unlock()
+		}
+	}
+	for _, call := range m.unexpected {
+		t.Errorf("unexpected request %s", call)
+	}
+}
+
+// Response returns an http.Response to r with the given status code and body.
+func Response(r *http.Request, statusCode int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
+
+// JSONResponse returns an http.Response to r with the given status code and v serialized
+// as the JSON body.
+func JSONResponse(r *http.Request, statusCode int, v interface{}) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	resp := Response(r, statusCode, body)
+	resp.Header.Set("Content-Type", "application/json")
+	return resp, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpxtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"goji.io/pat"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMockTransport(t *testing.T) {
+	t.Run("unordered", func(t *testing.T) {
+		mock := &MockTransport{}
+		getUser := mock.Expect("GET", "/users/:id").RespondWith(func(r *http.Request) (*http.Response, error) {
+			return JSONResponse(r, http.StatusOK, map[string]string{"id": pat.Param(r, "id")})
+		}).Times(2)
+		deleteUser := mock.Expect("DELETE", "/users/:id").Respond(http.StatusNoContent, nil)
+
+		client := http.Client{Transport: mock}
+		req, _ := http.NewRequest("DELETE", "https://api.example.com/users/bob", nil)
+		resp, err := client.Do(req)
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		for _, id := range []string{"alice", "carol"} {
+			resp, err = client.Get("https://api.example.com/users/" + id)
+			assert.NilError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NilError(t, err)
+			assert.Equal(t, `{"id":"`+id+`"}`, string(body))
+		}
+
+		assert.Equal(t, 2, getUser.Calls())
+		assert.Equal(t, 1, deleteUser.Calls())
+
+		ft := &fakeT{}
+		mock.Verify(ft)
+		assert.Check(t, is.Len(ft.errors, 0))
+	})
+
+	t.Run("unexpected and unmet", func(t *testing.T) {
+		mock := &MockTransport{}
+		mock.Expect("GET", "/users/:id")
+		mock.Expect("POST", "/users").Times(-1)
+
+		client := http.Client{Transport: mock}
+		_, err := client.Get("https://api.example.com/widgets")
+		assert.Check(t, is.ErrorContains(err, "httpxtest: unexpected request GET https://api.example.com/widgets"))
+
+		ft := &fakeT{}
+		mock.Verify(ft)
+		assert.Check(t, is.DeepEqual(ft.errors, []string{
+			"expected GET /users/:id 1 time(s), got 0",
+			"unexpected request GET https://api.example.com/widgets",
+		}))
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		mock := &MockTransport{}
+		getUser := mock.Expect("GET", "/users/:id").Times(5)
+
+		client := http.Client{Transport: mock}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if resp, err := client.Get("https://api.example.com/users/alice"); err == nil {
+					resp.Body.Close()
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 5, getUser.Calls())
+		ft := &fakeT{}
+		mock.Verify(ft)
+		assert.Check(t, is.Len(ft.errors, 15))
+	})
+
+	t.Run("ordered", func(t *testing.T) {
+		mock := &MockTransport{Ordered: true}
+		mock.Expect("POST", "/users")
+		mock.Expect("GET", "/users/:id")
+
+		client := http.Client{Transport: mock}
+		_, err := client.Get("https://api.example.com/users/alice")
+		assert.Check(t, is.ErrorContains(err, "unexpected request"))
+
+		_, err = client.Post("https://api.example.com/users", "application/json", nil)
+		assert.NilError(t, err)
+		_, err = client.Get("https://api.example.com/users/alice")
+		assert.NilError(t, err)
+
+		ft := &fakeT{}
+		mock.Verify(ft)
+		assert.Check(t, is.DeepEqual(ft.errors, []string{
+			"unexpected request GET https://api.example.com/users/alice",
+		}))
+	})
+}
@@ -15,7 +15,9 @@
 package httpx
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"reflect"
 
@@ -33,6 +35,34 @@ import (
 //
 // InputType and OutputType must be structs.
 //
+// Fields of InputType may be tagged to populate them from other parts of the request. Values are
+// converted to the field type, which may be a string, bool, number, time.Duration, time.Time
+// (RFC 3339), encoding.TextUnmarshaler, or a slice or pointer of those. If a value cannot be
+// converted, the handler responds with a public 400 error. When InputType has tagged fields, the
+// request body may be empty.
+//
+//   type InputType struct {
+//      ID     string    `path:"id" json:"-"`         // goji path variable
+//      Limit  int       `query:"limit" json:"-"`     // URL query parameter
+//      Tenant string    `header:"X-Tenant" json:"-"` // request header
+//      Since  time.Time `query:"since" json:"-"`
+//      Tags   []string  `query:"tag" json:"-"`       // every ?tag=... value
+//      Name   string                                 // from the JSON body
+//   }
+//
+// After decoding, InputType is checked against its `validate` struct tags and its Validate() method,
+// if it implements Validator. Violations are reported as a public 422 ValidationError that lists
+// each invalid field. For example:
+//
+//   type InputType struct {
+//      Name  string   `validate:"required,max=64"`
+//      Color string   `validate:"oneof=red green blue"`
+//      Code  string   `validate:"pattern=^[A-Z]{3}$"`
+//      Items []string `validate:"min=1"`
+//   }
+//
+// Malformed validate tags, e.g. unknown rules, cause a panic when the handler is created.
+//
 // The function must have one of the following signatures:
 //
 //    func(r *http.Request, in InputType) (*OutputType, error)
@@ -43,6 +73,8 @@ import (
 //    func(r *http.Request) (error)
 //    func(r *http.Request)
 //
+// JSONHandlerFunc, JSONInputHandlerFunc and JSONOutputHandlerFunc are type-safe alternatives
+// that do not use reflection.
 func JSONHandler(f interface{}) http.Handler {
 	fval := reflect.ValueOf(f)
 	ftyp := fval.Type()
@@ -81,12 +113,20 @@ func JSONHandler(f interface{}) http.Handler {
 		}
 	}
 
-	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+	h := jsonHandler{}
+	if ftyp.NumIn() == 2 {
+		h.in = ftyp.In(1)
+		mustCheckValidateTags(h.in)
+	}
+	if ftyp.NumOut() == 2 {
+		h.out = ftyp.Out(0).Elem()
+	}
+	h.HandlerFunc = func(w http.ResponseWriter, r *http.Request) error {
 		var out []reflect.Value
 		if ftyp.NumIn() == 2 {
 			reqBody := reflect.New(ftyp.In(1))
-			if err := json.NewDecoder(r.Body).Decode(reqBody.Interface()); err != nil {
-				return httperr.Public(http.StatusBadRequest, err)
+			if err := decodeJSONRequest(r, reqBody.Interface()); err != nil {
+				return err
 			}
 
 			out = fval.Call([]reflect.Value{reflect.ValueOf(r), reqBody.Elem()})
@@ -113,8 +153,137 @@ func JSONHandler(f interface{}) http.Handler {
 			return nil
 		}
 
-		respBody := out[0].Interface()
+		return writeJSONResponse(w, r, out[0].Interface())
+	}
+	return h
+}
+
+// JSONHandlerFunc returns an http handler that decodes the JSON request body into In, invokes f
+// and emits the result as JSON. It behaves like JSONHandler, but the signature of f is checked at
+// compile time rather than with reflection.
+//
+// Example usage:
+//
+//   mux.Post("/someendpoint", JSONHandlerFunc(func(r *http.Request, in InputType) (*OutputType, error) {
+//      /* implementation */
+//   }))
+//
+// In may be a struct or a pointer to a struct.
+func JSONHandlerFunc[In, Out any](f func(r *http.Request, in In) (*Out, error)) http.Handler {
+	h := jsonHandler{in: typeOf[In](), out: typeOf[Out]()}
+	mustCheckValidateTags(h.in)
+	h.HandlerFunc = func(w http.ResponseWriter, r *http.Request) error {
+		var in In
+		if err := decodeJSONRequest(r, &in); err != nil {
+			return err
+		}
+		out, err := f(r, in)
+		if err != nil {
+			return err
+		}
+		return writeJSONResponse(w, r, out)
+	}
+	return h
+}
+
+// JSONInputHandlerFunc is like JSONHandlerFunc, but for functions that do not produce output. If f
+// returns nil, the response has status 204 No Content.
+func JSONInputHandlerFunc[In any](f func(r *http.Request, in In) error) http.Handler {
+	h := jsonHandler{in: typeOf[In]()}
+	mustCheckValidateTags(h.in)
+	h.HandlerFunc = func(w http.ResponseWriter, r *http.Request) error {
+		var in In
+		if err := decodeJSONRequest(r, &in); err != nil {
+			return err
+		}
+		if err := f(r, in); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return h
+}
+
+// JSONOutputHandlerFunc is like JSONHandlerFunc, but for functions that do not take a request body.
+func JSONOutputHandlerFunc[Out any](f func(r *http.Request) (*Out, error)) http.Handler {
+	h := jsonHandler{out: typeOf[Out]()}
+	h.HandlerFunc = func(w http.ResponseWriter, r *http.Request) error {
+		out, err := f(r)
+		if err != nil {
+			return err
+		}
+		return writeJSONResponse(w, r, out)
+	}
+	return h
+}
+
+// jsonHandler is the http.Handler returned by JSONHandler and its generic variants. It records
+// the input and output types of the function so that they can be described by a Registry.
+type jsonHandler struct {
+	httperr.HandlerFunc
+	in  reflect.Type // nil if the function takes no input
+	out reflect.Type // nil if the function produces no output
+}
+
+func (h jsonHandler) jsonTypes() (in, out reflect.Type) {
+	return h.in, h.out
+}
+
+// jsonTyper is implemented by jsonHandler, and by middleware that wraps one, so that a Registry
+// can find the input and output types of a handler.
+type jsonTyper interface {
+	jsonTypes() (in, out reflect.Type)
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// decodeJSONRequest decodes the body of r into v, which must be a pointer, populates fields
+// tagged with path, query or header (see bindRequest), and validates the result (see
+// validateInput). If v has tagged fields, the body may be empty.
+func decodeJSONRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		if err != io.EOF || !hasBindings(v) {
+			return httperr.Public(http.StatusBadRequest, err)
+		}
+	}
+	if err := bindRequest(r, v); err != nil {
+		return err
+	}
+	return validateInput(v)
+}
+
+// writeJSONResponse emits v as the JSON response body. If conditional requests are enabled
+// for r (see ConditionalJSON), it sets the ETag header, and responds 304 Not Modified if it
+// matches If-None-Match.
+func writeJSONResponse(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if !conditionalJSONEnabled(r) {
 		w.Header().Add("Content-type", "application/json")
-		return json.NewEncoder(w).Encode(respBody)
-	})
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	body, err := encodeJSON(v)
+	if err != nil {
+		return err
+	}
+	etag := etagOf(v, body)
+	w.Header().Set("ETag", etag)
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagListMatches(r.Header.Values("If-None-Match"), etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Add("Content-type", "application/json")
+	_, err = w.Write(body)
+	return err
+}
+
+// encodeJSON returns the encoding of v written by writeJSONResponse.
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -90,6 +90,8 @@ func (c JSONClient) NewRequest(ctx context.Context, method string, uri string, r
 // HandleResponse handles an HTTP response containing a JSON object. If responseBody is provided, then
 // the response is unmarshalled into it. If the HTTP status code is >= 400, then OnError is invoked if
 // provided, otherwise an httperr.Response error is returned.
+//
+// To reconstruct errors emitted by httperr.Write in another service, set OnError to httperr.FromResponse.
 func (c JSONClient) HandleResponse(resp *http.Response, responseBody interface{}) error {
 	if resp.StatusCode >= 400 {
 		if c.OnError != nil {
@@ -124,3 +126,66 @@ func (c JSONClient) DoJSON(ctx context.Context, method string, uri string, reque
 	}
 	return c.HandleResponse(httpResp, response)
 }
+
+// Get performs an HTTP GET request using c and returns the JSON response body decoded into an Out.
+//
+// Example:
+//
+//    user, err := httpx.Get[User](ctx, c, "https://api.example.com/users/alice")
+//
+func Get[Out any](ctx context.Context, c JSONClient, uri string) (*Out, error) {
+	out, _, err := DoWithResponse[interface{}, Out](ctx, c, http.MethodGet, uri, nil)
+	return out, err
+}
+
+// Post performs an HTTP POST request using c with in as the JSON request body and returns the JSON
+// response body decoded into an Out.
+func Post[In, Out any](ctx context.Context, c JSONClient, uri string, in In) (*Out, error) {
+	out, _, err := DoWithResponse[In, Out](ctx, c, http.MethodPost, uri, in)
+	return out, err
+}
+
+// Do performs an HTTP request using c with in as the JSON request body and returns the JSON response
+// body decoded into an Out. Errors are handled as in JSONClient.DoJSON.
+//
+// To send a request without a body, use interface{} for In and pass nil.
+func Do[In, Out any](ctx context.Context, c JSONClient, method string, uri string, in In) (*Out, error) {
+	out, _, err := DoWithResponse[In, Out](ctx, c, method, uri, in)
+	return out, err
+}
+
+// DoWithResponse is like Do, but also returns the HTTP response so that the status code and headers
+// (e.g. for pagination or rate limits) can be inspected. The response body has already been consumed
+// and closed. If the server responds with 204 No Content, the returned *Out is nil. If the server
+// responds with an error, the body is read into memory first, so that it can still be read from the
+// returned response and error.
+func DoWithResponse[In, Out any](ctx context.Context, c JSONClient, method string, uri string, in In) (*Out, *http.Response, error) {
+	httpReq, err := c.NewRequest(ctx, method, uri, in)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpResp, err := c.Client.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode == http.StatusNoContent {
+		return nil, httpResp, nil
+	}
+
+	if httpResp.StatusCode >= 400 {
+		// the error returned by HandleResponse may refer to the body, e.g. httperr.Response,
+		// so it is read into memory to remain readable after the response is closed
+		if _, err := readBody(&httpResp.Body); err != nil {
+			return nil, httpResp, err
+		}
+	}
+
+	out := new(Out)
+	if err := c.HandleResponse(httpResp, out); err != nil {
+		return nil, httpResp, err
+	}
+	return out, httpResp, nil
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -27,6 +28,7 @@ import (
 	is "gotest.tools/assert/cmp"
 
 	"github.com/nametaginc/httpx/httperr"
+	"github.com/nametaginc/httpx/httpxtest"
 )
 
 type APIError struct {
@@ -44,11 +46,7 @@ type ResponseBody struct {
 	Bar string
 }
 
-type FakeServer func(r *http.Request) (*http.Response, error)
-
-func (f FakeServer) RoundTrip(r *http.Request) (*http.Response, error) {
-	return f(r)
-}
+type FakeServer = httpxtest.FakeServer
 
 type CannotMarshal struct{}
 
@@ -240,3 +238,145 @@ func TestJSONClient(t *testing.T) {
 		assert.Equal(t, string(body), `{"Code": 1337, "Message": "good hacker. have cookie"}`)
 	})
 }
+
+type closeTrackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTypedJSONClient(t *testing.T) {
+	t.Run("get", func(t *testing.T) {
+		ctx := context.Background()
+		c := JSONClient{Client: &http.Client{
+			Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+				assert.Equal(t, "GET", r.Method)
+				assert.Equal(t, "https://api.example.com/foo", r.URL.String())
+				assert.Equal(t, r.Header.Get("Accept"), "application/json")
+				assert.Check(t, is.Nil(r.Body))
+
+				resp := &http.Response{StatusCode: http.StatusOK}
+				resp.Body = ioutil.NopCloser(strings.NewReader(`{"Bar": "baz"}`))
+				return resp, nil
+			}),
+		}}
+
+		resp, err := Get[ResponseBody](ctx, c, "https://api.example.com/foo")
+		assert.Check(t, err)
+		assert.DeepEqual(t, resp, &ResponseBody{Bar: "baz"})
+	})
+
+	t.Run("post", func(t *testing.T) {
+		ctx := context.Background()
+		c := JSONClient{Client: &http.Client{
+			Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+				assert.Equal(t, "POST", r.Method)
+				reqBody, err := ioutil.ReadAll(r.Body)
+				assert.Check(t, err)
+				assert.Equal(t, `{"Foo":"foo"}`, string(reqBody))
+
+				resp := &http.Response{StatusCode: http.StatusOK}
+				resp.Body = ioutil.NopCloser(strings.NewReader(`{"Bar": "baz"}`))
+				return resp, nil
+			}),
+		}}
+
+		resp, err := Post[RequestBody, ResponseBody](ctx, c, "https://api.example.com/foo", RequestBody{Foo: "foo"})
+		assert.Check(t, err)
+		assert.DeepEqual(t, resp, &ResponseBody{Bar: "baz"})
+	})
+
+	t.Run("with response", func(t *testing.T) {
+		ctx := context.Background()
+		c := JSONClient{Client: &http.Client{
+			Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+				resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+				resp.Header.Set("Link", `<https://api.example.com/foo?page=2>; rel="next"`)
+				resp.Body = ioutil.NopCloser(strings.NewReader(`{"Bar": "baz"}`))
+				return resp, nil
+			}),
+		}}
+
+		resp, httpResp, err := DoWithResponse[RequestBody, ResponseBody](ctx, c, "PUT", "https://api.example.com/foo", RequestBody{Foo: "foo"})
+		assert.Check(t, err)
+		assert.DeepEqual(t, resp, &ResponseBody{Bar: "baz"})
+		assert.Equal(t, http.StatusOK, httpResp.StatusCode)
+		assert.Equal(t, `<https://api.example.com/foo?page=2>; rel="next"`, httpResp.Header.Get("Link"))
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		ctx := context.Background()
+		c := JSONClient{Client: &http.Client{
+			Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+				resp := &http.Response{StatusCode: http.StatusNoContent}
+				resp.Body = ioutil.NopCloser(strings.NewReader(``))
+				return resp, nil
+			}),
+		}}
+
+		resp, err := Do[interface{}, ResponseBody](ctx, c, "DELETE", "https://api.example.com/foo", nil)
+		assert.Check(t, err)
+		assert.Check(t, is.Nil(resp))
+	})
+
+	t.Run("error body", func(t *testing.T) {
+		ctx := context.Background()
+		body := &closeTrackingBody{Reader: strings.NewReader(`{"error": "frob"}`)}
+		c := JSONClient{Client: &http.Client{
+			Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusBadRequest, Body: body}, nil
+			}),
+		}}
+		_, httpResp, err := DoWithResponse[interface{}, ResponseBody](ctx, c, "GET", "https://api.example.com/foo", nil)
+		assert.Check(t, body.closed)
+		errBody, readErr := ioutil.ReadAll(err.(httperr.Response).Body)
+		assert.Check(t, readErr)
+		assert.Check(t, is.Equal(`{"error": "frob"}`, string(errBody)))
+		assert.Check(t, httpResp != nil)
+	})
+
+	t.Run("closes body", func(t *testing.T) {
+		ctx := context.Background()
+		for _, tc := range []struct {
+			Status int
+			Body   string
+		}{
+			{http.StatusOK, `{"Bar": "baz"}`},
+			{http.StatusOK, `not json`},
+			{http.StatusNoContent, ``},
+			{http.StatusInternalServerError, `oops`},
+		} {
+			body := &closeTrackingBody{Reader: strings.NewReader(tc.Body)}
+			c := JSONClient{Client: &http.Client{
+				Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: tc.Status, Body: body}, nil
+				}),
+			}}
+			_, _, _ = DoWithResponse[interface{}, ResponseBody](ctx, c, "GET", "https://api.example.com/foo", nil)
+			assert.Check(t, body.closed, "status %d, body %q", tc.Status, tc.Body)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ctx := context.Background()
+		c := JSONClient{Client: &http.Client{
+			Transport: FakeServer(func(r *http.Request) (*http.Response, error) {
+				resp := &http.Response{StatusCode: http.StatusTeapot}
+				resp.Body = ioutil.NopCloser(strings.NewReader(`{"Code": 1337, "Message": "good hacker. have cookie"}`))
+				return resp, nil
+			}),
+		}}
+		c.OnError = HandleJSONError(func() error { return &APIError{} })
+
+		resp, err := Get[ResponseBody](ctx, c, "https://api.example.com/foo")
+		assert.Check(t, is.Nil(resp))
+		assert.DeepEqual(t, err.(*APIError), &APIError{
+			Code:    1337,
+			Message: "good hacker. have cookie",
+		})
+	})
+}
@@ -16,9 +16,13 @@ package httpx
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"gotest.tools/assert"
+
+	"github.com/nametaginc/httpx/httperr"
 )
 
 type TestInputType struct{}
@@ -50,3 +54,81 @@ func TestHttpHandlerTypeSignaturesArePanicFree(t *testing.T) {
 	}
 	assert.Check(t, JSONHandler(requestOnly) != nil)
 }
+
+func TestJSONHandlerFunc(t *testing.T) {
+	type Input struct {
+		Foo string
+	}
+	type Output struct {
+		Bar string
+	}
+
+	t.Run("success", func(t *testing.T) {
+		h := JSONHandlerFunc(func(r *http.Request, in Input) (*Output, error) {
+			return &Output{Bar: in.Foo + "!"}, nil
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"Foo": "foo"}`))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-type"))
+		assert.Equal(t, `{"Bar":"foo!"}`+"\n", w.Body.String())
+	})
+
+	t.Run("pointer input", func(t *testing.T) {
+		h := JSONHandlerFunc(func(r *http.Request, in *Input) (*Output, error) {
+			return &Output{Bar: in.Foo}, nil
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"Foo": "foo"}`))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"Bar":"foo"}`+"\n", w.Body.String())
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		h := JSONHandlerFunc(func(r *http.Request, in Input) (*Output, error) {
+			panic("not reached")
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{invalid json`))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "invalid character 'i' looking for beginning of object key string", w.Header().Get("X-Error-Message"))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h := JSONHandlerFunc(func(r *http.Request, in Input) (*Output, error) {
+			return nil, httperr.Teapot
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
+
+	t.Run("input only", func(t *testing.T) {
+		var got Input
+		h := JSONInputHandlerFunc(func(r *http.Request, in Input) error {
+			got = in
+			return nil
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"Foo": "foo"}`))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "", w.Body.String())
+		assert.DeepEqual(t, got, Input{Foo: "foo"})
+	})
+
+	t.Run("output only", func(t *testing.T) {
+		h := JSONOutputHandlerFunc(func(r *http.Request) (*Output, error) {
+			return &Output{Bar: "baz"}, nil
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"Bar":"baz"}`+"\n", w.Body.String())
+	})
+}
@@ -0,0 +1,246 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxLoggedBodySize = 4096
+
+// Logger is a structured logger. Args are alternating keys and values. It is
+// implemented by *slog.Logger.
+type Logger interface {
+	InfoContext(ctx context.Context, msg string, args ...interface{})
+	ErrorContext(ctx context.Context, msg string, args ...interface{})
+}
+
+// LoggingTransport is an http.RoundTripper that logs each request with its method, URL,
+// status, latency and body sizes.
+//
+// If LogHeaders is set, request and response headers are logged. The values of the
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie headers, and of any headers
+// in RedactHeaders, are replaced with "REDACTED". Passwords in URLs are always redacted.
+//
+// If LogBodies is set, request and response bodies are logged, with the values of any JSON
+// object fields named in RedactFields replaced with "REDACTED", truncated to MaxBodySize
+// bytes (4096 if zero). Request bodies are read into memory. Response bodies are streamed
+// to the caller, keeping only the first MaxBodySize bytes, and the request is logged once
+// the response body has been read to the end or closed. Response bodies longer than
+// MaxBodySize, and bodies that are not JSON, cannot be redacted, so they are not logged if
+// RedactFields is set.
+//
+// Requests that fail are logged at the error level, and others at the info level.
+//
+// e.g.
+//
+//   transport := LoggingTransport{
+//      Next:         http.DefaultTransport,
+//      Logger:       slog.Default(),
+//      LogBodies:    true,
+//      RedactFields: []string{"password", "ssn"},
+//   }
+//
+type LoggingTransport struct {
+	Next          http.RoundTripper
+	Logger        Logger
+	LogHeaders    bool
+	LogBodies     bool
+	MaxBodySize   int
+	RedactHeaders []string
+	RedactFields  []string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t LoggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	args := []interface{}{"method", r.Method, "url", redactURL(r.URL)}
+	if t.LogHeaders {
+		args = append(args, "request_header", t.redactHeader(r.Header))
+	}
+
+	requestSize := r.ContentLength
+	if t.LogBodies {
+		body, err := readBody(&r.Body)
+		if err != nil {
+			return nil, err
+		}
+		requestSize = int64(len(body))
+		if len(body) > 0 {
+			args = append(args, "request_body", t.formatBody(body, false))
+		}
+	}
+	args = append(args, "request_size", requestSize)
+
+	start := time.Now()
+	resp, err := t.Next.RoundTrip(r)
+	args = append(args, "duration", time.Since(start))
+	if err != nil {
+		t.Logger.ErrorContext(ctx, "http request failed", append(args, "error", err.Error())...)
+		return nil, err
+	}
+
+	args = append(args, "status", resp.StatusCode)
+	if t.LogHeaders {
+		args = append(args, "response_header", t.redactHeader(resp.Header))
+	}
+	if t.LogBodies && resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &loggedBody{
+			ReadCloser: resp.Body,
+			maxSize:    t.maxBodySize(),
+			done: func(body []byte, size int64, err error) {
+				if len(body) > 0 {
+					args = append(args, "response_body", t.formatBody(body, size > int64(len(body))))
+				}
+				args = append(args, "response_size", size)
+				if err != nil {
+					t.Logger.ErrorContext(ctx, "http request failed", append(args, "error", err.Error())...)
+					return
+				}
+				t.Logger.InfoContext(ctx, "http request", args...)
+			},
+		}
+		return resp, nil
+	}
+
+	responseSize := resp.ContentLength
+	if t.LogBodies {
+		responseSize = 0
+	}
+	args = append(args, "response_size", responseSize)
+
+	t.Logger.InfoContext(ctx, "http request", args...)
+	return resp, nil
+}
+
+func (t LoggingTransport) maxBodySize() int {
+	if t.MaxBodySize == 0 {
+		return defaultMaxLoggedBodySize
+	}
+	return t.MaxBodySize
+}
+
+func (t LoggingTransport) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range append([]string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}, t.RedactHeaders...) {
+		if len(h.Values(name)) > 0 {
+			h.Set(name, redacted)
+		}
+	}
+	return h
+}
+
+// formatBody returns body as a string for logging, with JSON fields redacted and
+// truncated to MaxBodySize. If RedactFields is set, bodies that cannot be redacted, because
+// they are not JSON or truncated is set, are replaced with a placeholder.
+func (t LoggingTransport) formatBody(body []byte, truncated bool) string {
+	if len(t.RedactFields) > 0 {
+		if truncated {
+			return "(truncated, not logged because it cannot be redacted)"
+		}
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return "(not JSON, not logged because it cannot be redacted)"
+		}
+		buf, err := json.Marshal(redactJSON(v, t.RedactFields))
+		if err != nil {
+			return "(not logged because it cannot be redacted)"
+		}
+		body = buf
+	}
+
+	if maxSize := t.maxBodySize(); len(body) > maxSize {
+		return string(body[:maxSize]) + "...(truncated)"
+	} else if truncated {
+		return string(body) + "...(truncated)"
+	}
+	return string(body)
+}
+
+// loggedBody is a response body that keeps the first maxSize bytes read from it, and
+// calls done with them and the total size once it has been read to the end, has failed,
+// or has been closed.
+type loggedBody struct {
+	io.ReadCloser
+	maxSize int
+	done    func(body []byte, size int64, err error)
+
+	buf  bytes.Buffer
+	size int64
+	once sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if room := b.maxSize - b.buf.Len(); room > 0 {
+		if room > n {
+			room = n
+		}
+		b.buf.Write(p[:room])
+	}
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *loggedBody) finish(err error) {
+	b.once.Do(func() {
+		b.done(b.buf.Bytes(), b.size, err)
+	})
+}
+
+// redactJSON replaces the values of object fields with the given names in a decoded JSON
+// value, at any depth. Names are compared case-insensitively.
+func redactJSON(v interface{}, fields []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range v {
+			matched := false
+			for _, field := range fields {
+				if strings.EqualFold(k, field) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				v[k] = redacted
+			} else {
+				v[k] = redactJSON(fv, fields)
+			}
+		}
+	case []interface{}:
+		for i, ev := range v {
+			v[i] = redactJSON(ev, fields)
+		}
+	}
+	return v
+}
@@ -0,0 +1,473 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// Algorithms for HTTP Message Signatures, as registered in RFC 9421 section 6.2.
+const (
+	SignatureAlgorithmHMACSHA256      = "hmac-sha256"
+	SignatureAlgorithmEd25519         = "ed25519"
+	SignatureAlgorithmECDSAP256SHA256 = "ecdsa-p256-sha256"
+)
+
+const (
+	defaultSignatureLabel  = "sig1"
+	defaultSignatureMaxAge = 5 * time.Minute
+)
+
+// MessageSigningTransport is an http.RoundTripper that signs requests using HTTP Message
+// Signatures, adding Signature-Input and Signature headers.
+//
+// Key determines the algorithm. It is a []byte for hmac-sha256, an ed25519.PrivateKey for
+// ed25519, or an *ecdsa.PrivateKey on the P-256 curve for ecdsa-p256-sha256.
+//
+// Components lists the covered components, which are either derived components, e.g.
+// "@method", "@target-uri", "@authority", "@scheme", "@request-target", "@path" and
+// "@query", or lowercase header names. If Components is empty, "@method" and "@target-uri"
+// are covered, as well as "content-digest" if the request has a Content-Digest header.
+// Label names the signature, and defaults to "sig1".
+//
+// ref: https://www.rfc-editor.org/rfc/rfc9421
+//
+// e.g.
+//
+//   transport := &MessageSigningTransport{
+//      Next:       http.DefaultTransport,
+//      KeyID:      "test-key-ed25519",
+//      Key:        privateKey,
+//      Components: []string{"@method", "@target-uri", "content-digest"},
+//   }
+//
+type MessageSigningTransport struct {
+	Next       http.RoundTripper
+	KeyID      string
+	Key        interface{}
+	Components []string
+	Label      string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *MessageSigningTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	alg, err := signatureAlgorithm(t.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	components := t.Components
+	if len(components) == 0 {
+		components = []string{"@method", "@target-uri"}
+		if r.Header.Get("Content-Digest") != "" {
+			components = append(components, "content-digest")
+		}
+	}
+	label := t.Label
+	if label == "" {
+		label = defaultSignatureLabel
+	}
+
+	quoted := make([]string, len(components))
+	for i, c := range components {
+		quoted[i] = strconv.Quote(c)
+	}
+	params := fmt.Sprintf("(%s);created=%d;keyid=%s;alg=%s",
+		strings.Join(quoted, " "), time.Now().Unix(), strconv.Quote(t.KeyID), strconv.Quote(alg))
+
+	base, err := signatureBase(r, components, params)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signMessage(t.Key, []byte(base))
+	if err != nil {
+		return nil, err
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Signature-Input", label+"="+params)
+	r.Header.Set("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return t.Next.RoundTrip(r)
+}
+
+// MessageSignatureVerifier is middleware that verifies HTTP Message Signatures, such as
+// those added by MessageSigningTransport.
+//
+// Keys maps key IDs to keys. Each key is a []byte for hmac-sha256, an ed25519.PublicKey
+// for ed25519, or an *ecdsa.PublicKey on the P-256 curve for ecdsa-p256-sha256.
+//
+// A request is accepted if at least one of its signatures is valid, was made with a key
+// in Keys, covers every component in Required ("@method" and "@target-uri" if empty), and
+// was created no more than MaxAge ago (5 minutes if zero). Otherwise, the request fails
+// with a public 401 error describing the problem.
+//
+// The "@target-uri", "@authority" and "@scheme" components are derived from the request
+// as received, so a server behind a proxy that changes the host or scheme should cover
+// other components instead.
+//
+// Covering "content-digest" only protects the value of the Content-Digest header. The body
+// is checked against it only if VerifyContentDigest is also used, e.g.
+// mux.Use(VerifyContentDigest) after verifier.Middleware.
+//
+// e.g.
+//
+//   verifier := &MessageSignatureVerifier{
+//      Keys:     map[string]interface{}{"test-key-ed25519": publicKey},
+//      Required: []string{"@method", "@target-uri", "content-digest"},
+//   }
+//   mux.Use(verifier.Middleware)
+//
+type MessageSignatureVerifier struct {
+	Keys     map[string]interface{}
+	Required []string
+	MaxAge   time.Duration
+}
+
+// Middleware returns a handler that verifies the signature of each request before
+// passing it to next.
+func (v *MessageSignatureVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.verify(r); err != nil {
+			httperr.ReportError(w, r, httperr.Public(http.StatusUnauthorized, err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (v *MessageSignatureVerifier) verify(r *http.Request) error {
+	inputs, err := parseDictionary(strings.Join(r.Header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return fmt.Errorf("invalid Signature-Input header: %v", err)
+	}
+	signatures, err := parseDictionary(strings.Join(r.Header.Values("Signature"), ", "))
+	if err != nil {
+		return fmt.Errorf("invalid Signature header: %v", err)
+	}
+	if len(inputs) == 0 {
+		return errors.New("request is not signed")
+	}
+
+	labels := make([]string, 0, len(inputs))
+	for label := range inputs {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var firstErr error
+	for _, label := range labels {
+		err := v.verifySignature(r, inputs[label], signatures[label])
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("signature %s: %v", label, err)
+		}
+	}
+	return firstErr
+}
+
+func (v *MessageSignatureVerifier) verifySignature(r *http.Request, input, signature string) error {
+	if !strings.HasPrefix(signature, ":") || !strings.HasSuffix(signature, ":") || len(signature) < 2 {
+		return errors.New("missing signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature[1 : len(signature)-1])
+	if err != nil {
+		return errors.New("signature is not valid base64")
+	}
+
+	components, params, err := parseSignatureInput(input)
+	if err != nil {
+		return err
+	}
+
+	required := v.Required
+	if len(required) == 0 {
+		required = []string{"@method", "@target-uri"}
+	}
+	for _, c := range required {
+		if !containsString(components, c) {
+			return fmt.Errorf("signature does not cover %s", c)
+		}
+	}
+
+	key, ok := v.Keys[params["keyid"]]
+	if !ok {
+		return errors.New("unknown signing key")
+	}
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return err
+	}
+	if want, ok := params["alg"]; ok && want != alg {
+		return fmt.Errorf("algorithm %s does not match key", want)
+	}
+
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultSignatureMaxAge
+	}
+	created, err := strconv.ParseInt(params["created"], 10, 64)
+	if err != nil {
+		return errors.New("signature has no creation time")
+	}
+	now := time.Now()
+	if createdAt := time.Unix(created, 0); createdAt.Before(now.Add(-maxAge)) || createdAt.After(now.Add(maxAge)) {
+		return errors.New("signature is too old")
+	}
+	if expires, ok := params["expires"]; ok {
+		expires, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || now.After(time.Unix(expires, 0)) {
+			return errors.New("signature has expired")
+		}
+	}
+
+	base, err := signatureBase(r, components, input)
+	if err != nil {
+		return err
+	}
+	if !verifyMessage(key, []byte(base), sig) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// signatureBase returns the signature base of r for the covered components, given the
+// serialized signature parameters.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc9421#section-2.5
+func signatureBase(r *http.Request, components []string, params string) (string, error) {
+	var b strings.Builder
+	for _, c := range components {
+		value, err := componentValue(r, c)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%q: %s\n", c, value)
+	}
+	fmt.Fprintf(&b, "%q: %s", "@signature-params", params)
+	return b.String(), nil
+}
+
+// componentValue returns the value of a covered component of r.
+func componentValue(r *http.Request, name string) (string, error) {
+	scheme := strings.ToLower(r.URL.Scheme)
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	authority := r.Host
+	if authority == "" {
+		authority = r.URL.Host
+	}
+	authority = strings.ToLower(authority)
+
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return scheme + "://" + authority + r.URL.RequestURI(), nil
+	case "@authority":
+		return authority, nil
+	case "@scheme":
+		return scheme, nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("unsupported component %s", name)
+	}
+
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("request has no %s header", name)
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+// signatureAlgorithm returns the name of the algorithm used with key.
+func signatureAlgorithm(key interface{}) (string, error) {
+	switch key := key.(type) {
+	case []byte:
+		return SignatureAlgorithmHMACSHA256, nil
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return SignatureAlgorithmEd25519, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			return SignatureAlgorithmECDSAP256SHA256, nil
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return SignatureAlgorithmECDSAP256SHA256, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported signing key type %T", key)
+}
+
+func signMessage(key interface{}, base []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(base)
+		return mac.Sum(nil), nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, base), nil
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(base)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// the signature is r and s as fixed length big-endian integers, not ASN.1
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported signing key type %T", key)
+}
+
+func verifyMessage(key interface{}, base, sig []byte) bool {
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(base)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, base, sig)
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(base)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+// parseSignatureInput parses a member of the Signature-Input dictionary, returning the
+// covered components and the signature parameters.
+func parseSignatureInput(input string) (components []string, params map[string]string, err error) {
+	if !strings.HasPrefix(input, "(") {
+		return nil, nil, errors.New("invalid signature input")
+	}
+	end := strings.IndexByte(input, ')')
+	if end < 0 {
+		return nil, nil, errors.New("invalid signature input")
+	}
+	for _, item := range strings.Fields(input[1:end]) {
+		c, err := strconv.Unquote(item)
+		if err != nil || !strings.HasPrefix(item, `"`) {
+			return nil, nil, fmt.Errorf("unsupported component %s", item)
+		}
+		components = append(components, c)
+	}
+
+	params = map[string]string{}
+	for _, param := range strings.Split(input[end+1:], ";")[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		}
+		params[name] = value
+	}
+	return components, params, nil
+}
+
+// parseDictionary splits a structured field dictionary into its members, returning the
+// unparsed value of each.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc8941#section-3.2
+func parseDictionary(s string) (map[string]string, error) {
+	members := map[string]string{}
+	inString, depth, start := false, 0, 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch c := s[i]; {
+			case inString && c == '\\':
+				i++
+				continue
+			case c == '"':
+				inString = !inString
+				continue
+			case inString:
+				continue
+			case c == '(':
+				depth++
+				continue
+			case c == ')':
+				depth--
+				continue
+			case c != ',' || depth > 0:
+				continue
+			}
+		}
+		member := strings.TrimSpace(s[start:i])
+		start = i + 1
+		if member == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(member, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid member %q", member)
+		}
+		members[name] = value
+	}
+	if inString || depth != 0 {
+		return nil, errors.New("unterminated value")
+	}
+	return members, nil
+}
+
+// containsString returns true if s is in list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestSignatureBase(t *testing.T) {
+	// ref: https://www.rfc-editor.org/rfc/rfc9421#appendix-B.2.6
+	seed, err := base64.RawURLEncoding.DecodeString("n4Ni-HpISpVObnQMW0wOhCKROaIKqKtW_2ZYb2p9KcU")
+	assert.NilError(t, err)
+	key := ed25519.NewKeyFromSeed(seed)
+
+	r, err := http.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	assert.NilError(t, err)
+	r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Length", "18")
+
+	r.Header.Add("X-Padded", "  a ")
+	r.Header.Add("X-Padded", "b  ")
+	_, err = signatureBase(r, []string{"x-padded"}, "")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"  a ", "b  "}, r.Header.Values("X-Padded"))
+	r.Header.Del("X-Padded")
+
+	params := `("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`
+	base, err := signatureBase(r, []string{"date", "@method", "@path", "@authority", "content-type", "content-length"}, params)
+	assert.NilError(t, err)
+	assert.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@method": POST
+"@path": /foo
+"@authority": example.com
+"content-type": application/json
+"content-length": 18
+"@signature-params": `+params, base)
+
+	sig, err := signMessage(key, []byte(base))
+	assert.NilError(t, err)
+	assert.Equal(t, "wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==",
+		base64.StdEncoding.EncodeToString(sig))
+}
+
+func TestMessageSignatures(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NilError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	hmacKey := []byte("hunter2")
+
+	verifier := &MessageSignatureVerifier{
+		Keys: map[string]interface{}{
+			"hmac":    hmacKey,
+			"ed25519": edKey.Public(),
+			"ecdsa":   &ecKey.PublicKey,
+		},
+		Required: []string{"@method", "@target-uri", "content-type"},
+	}
+	server := verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	post := func(t *testing.T, transport http.RoundTripper) *http.Response {
+		client := http.Client{Transport: transport}
+		resp, err := client.Post("https://api.example.com/foo?a=b", "application/json", strings.NewReader(`{}`))
+		assert.NilError(t, err)
+		return resp
+	}
+	components := []string{"@method", "@target-uri", "content-type"}
+
+	t.Run("valid", func(t *testing.T) {
+		for keyID, key := range map[string]interface{}{"hmac": hmacKey, "ed25519": edKey, "ecdsa": ecKey} {
+			transport := &MessageSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: keyID, Key: key, Components: components}
+			resp := post(t, transport)
+			assert.Check(t, is.Equal(http.StatusNoContent, resp.StatusCode), keyID)
+		}
+	})
+
+	t.Run("header values", func(t *testing.T) {
+		check := FakeServer(func(r *http.Request) (*http.Response, error) {
+			assert.Check(t, strings.HasPrefix(r.Header.Get("Signature-Input"),
+				`sig1=("@method" "@target-uri" "content-type");created=`))
+			assert.Check(t, strings.HasSuffix(r.Header.Get("Signature-Input"), `;keyid="ed25519";alg="ed25519"`))
+			assert.Check(t, strings.HasPrefix(r.Header.Get("Signature"), "sig1=:"))
+			return HandlerTransport{Handler: server}.RoundTrip(r)
+		})
+		transport := &MessageSigningTransport{Next: check, KeyID: "ed25519", Key: edKey, Components: components}
+		assert.Equal(t, http.StatusNoContent, post(t, transport).StatusCode)
+	})
+
+	reject := func(t *testing.T, transport http.RoundTripper, message string) {
+		t.Helper()
+		resp := post(t, transport)
+		assert.Check(t, is.Equal(http.StatusUnauthorized, resp.StatusCode))
+		assert.Check(t, is.Contains(resp.Header.Get("X-Error-Message"), message))
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		reject(t, HandlerTransport{Handler: server}, "request is not signed")
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		transport := &MessageSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: "other", Key: hmacKey, Components: components}
+		reject(t, transport, "unknown signing key")
+	})
+
+	t.Run("wrong algorithm", func(t *testing.T) {
+		transport := &MessageSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: "hmac", Key: edKey, Components: components}
+		reject(t, transport, "algorithm ed25519 does not match key")
+	})
+
+	t.Run("missing component", func(t *testing.T) {
+		transport := &MessageSigningTransport{Next: HandlerTransport{Handler: server}, KeyID: "hmac", Key: hmacKey}
+		reject(t, transport, "signature does not cover content-type")
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tamper := FakeServer(func(r *http.Request) (*http.Response, error) {
+			r.URL.RawQuery = "a=c"
+			return HandlerTransport{Handler: server}.RoundTrip(r)
+		})
+		transport := &MessageSigningTransport{Next: tamper, KeyID: "ecdsa", Key: ecKey, Components: components}
+		reject(t, transport, "signature does not match")
+	})
+
+	t.Run("too old", func(t *testing.T) {
+		stale := FakeServer(func(r *http.Request) (*http.Response, error) {
+			created := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+			input := r.Header.Get("Signature-Input")
+			input = input[:strings.Index(input, "created=")] + "created=" + created + `;keyid="hmac";alg="hmac-sha256"`
+			r.Header.Set("Signature-Input", input)
+			return HandlerTransport{Handler: server}.RoundTrip(r)
+		})
+		transport := &MessageSigningTransport{Next: stale, KeyID: "hmac", Key: hmacKey, Components: components}
+		reject(t, transport, "signature is too old")
+	})
+}
@@ -0,0 +1,365 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goji.io/middleware"
+)
+
+// Names of the metrics recorded by MetricsTransport and MetricsMiddleware.
+const (
+	ClientRequestsTotal          = "httpx_client_requests_total"
+	ClientRequestDurationSeconds = "httpx_client_request_duration_seconds"
+	ClientRequestsInFlight       = "httpx_client_requests_in_flight"
+	ServerRequestsTotal          = "httpx_server_requests_total"
+	ServerRequestDurationSeconds = "httpx_server_request_duration_seconds"
+	ServerRequestsInFlight       = "httpx_server_requests_in_flight"
+)
+
+var metricHelp = map[string]string{
+	ClientRequestsTotal:          "Total number of outgoing HTTP requests.",
+	ClientRequestDurationSeconds: "Time until the response header of outgoing HTTP requests was received.",
+	ClientRequestsInFlight:       "Number of outgoing HTTP requests waiting for a response.",
+	ServerRequestsTotal:          "Total number of HTTP requests served.",
+	ServerRequestDurationSeconds: "Time taken to serve HTTP requests.",
+	ServerRequestsInFlight:       "Number of HTTP requests being served.",
+}
+
+// Labels are the names and values of the labels of a metric.
+type Labels map[string]string
+
+// Metrics is a backend that records metrics. It is implemented by MetricsRegistry, and
+// can be implemented to send metrics elsewhere.
+type Metrics interface {
+	// AddCounter adds delta to a counter.
+	AddCounter(name string, labels Labels, delta float64)
+
+	// AddGauge adds delta, which may be negative, to a gauge.
+	AddGauge(name string, labels Labels, delta float64)
+
+	// ObserveHistogram records a value in a histogram.
+	ObserveHistogram(name string, labels Labels, value float64)
+}
+
+// MetricsTransport is an http.RoundTripper that records the number of requests by host,
+// method and status, the latency of requests by host and method, and the number of
+// requests in flight by host. Requests that fail without a response have the status
+// "error".
+//
+// e.g.
+//
+//   registry := &MetricsRegistry{}
+//   transport := MetricsTransport{Next: http.DefaultTransport, Metrics: registry}
+//   mux.Handle(pat.Get("/metrics"), registry)
+//
+type MetricsTransport struct {
+	Next    http.RoundTripper
+	Metrics Metrics
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t MetricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.URL.Host
+	if r.Host != "" {
+		host = r.Host
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	t.Metrics.AddGauge(ClientRequestsInFlight, Labels{"host": host}, 1)
+	defer t.Metrics.AddGauge(ClientRequestsInFlight, Labels{"host": host}, -1)
+
+	start := time.Now()
+	resp, err := t.Next.RoundTrip(r)
+	t.Metrics.ObserveHistogram(ClientRequestDurationSeconds, Labels{"host": host, "method": method}, time.Since(start).Seconds())
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	t.Metrics.AddCounter(ClientRequestsTotal, Labels{"host": host, "method": method, "status": status}, 1)
+	return resp, err
+}
+
+// MetricsMiddleware returns middleware that records the number of requests by method,
+// pattern and status, the latency of requests by method and pattern, and the number of
+// requests in flight.
+//
+// Requests are labelled with the goji pattern that matched them, e.g. "/users/:id", rather
+// than their path, so that the number of series does not grow with the number of distinct
+// paths. Requests that did not match a pattern have the pattern "none". The middleware
+// must be installed on a goji mux with Use for the pattern to be known. Likewise, requests
+// with a method not defined by RFC 9110 or RFC 5789 have the method "OTHER".
+//
+// e.g.
+//
+//   registry := &MetricsRegistry{}
+//   mux := goji.NewMux()
+//   mux.Use(MetricsMiddleware(registry))
+//   mux.Handle(pat.Get("/metrics"), registry)
+//
+func MetricsMiddleware(m Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.AddGauge(ServerRequestsInFlight, nil, 1)
+			defer m.AddGauge(ServerRequestsInFlight, nil, -1)
+
+			start := time.Now()
+			tw := WrapResponseWriter(w)
+			next.ServeHTTP(tw, r)
+
+			method := metricsMethod(r.Method)
+			pattern := routePattern(r)
+			status := tw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.ObserveHistogram(ServerRequestDurationSeconds, Labels{"method": method, "pattern": pattern}, time.Since(start).Seconds())
+			m.AddCounter(ServerRequestsTotal, Labels{"method": method, "pattern": pattern, "status": strconv.Itoa(status)}, 1)
+		})
+	}
+}
+
+// metricsMethod returns method if it is a standard HTTP method, or "OTHER", so that clients
+// cannot create arbitrarily many series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// routePattern returns the goji pattern that matched r, or "none".
+func routePattern(r *http.Request) string {
+	if p, ok := middleware.Pattern(r.Context()).(fmt.Stringer); ok {
+		return p.String()
+	}
+	return "none"
+}
+
+// DefaultBuckets are the upper bounds of histogram buckets used by MetricsRegistry if
+// Buckets is empty. They are suitable for request latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsRegistry is a Metrics that keeps metrics in memory, and serves them in the
+// Prometheus text exposition format.
+//
+// Buckets are the upper bounds of the buckets of every histogram, and default to
+// DefaultBuckets. They are fixed when the registry is first used, so changes made
+// afterwards are ignored. A metric name that has been used with one kind of metric is ignored
+// when used with another.
+//
+// ref: https://prometheus.io/docs/instrumenting/exposition_formats/
+type MetricsRegistry struct {
+	Buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*metricFamily
+	bounds   []float64 // Buckets when first used
+}
+
+type metricKind string
+
+const (
+	counterKind   metricKind = "counter"
+	gaugeKind     metricKind = "gauge"
+	histogramKind metricKind = "histogram"
+)
+
+type metricFamily struct {
+	kind   metricKind
+	series map[string]*metricSeries // by formatted labels
+}
+
+type metricSeries struct {
+	labels Labels
+	value  float64  // the sum, for histograms
+	counts []uint64 // for histograms, the count in each bucket, and then the total
+}
+
+// AddCounter implements Metrics.
+func (m *MetricsRegistry) AddCounter(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.series(name, counterKind, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// AddGauge implements Metrics.
+func (m *MetricsRegistry) AddGauge(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.series(name, gaugeKind, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// ObserveHistogram implements Metrics.
+func (m *MetricsRegistry) ObserveHistogram(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.series(name, histogramKind, labels)
+	if s == nil {
+		return
+	}
+	buckets := m.buckets()
+	if s.counts == nil {
+		s.counts = make([]uint64, len(buckets)+1)
+	}
+	for i, bound := range buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.counts[len(buckets)]++
+	s.value += value
+}
+
+// buckets returns the bucket bounds of histograms. m.mu must be held.
+func (m *MetricsRegistry) buckets() []float64 {
+	if m.bounds == nil {
+		m.bounds = DefaultBuckets
+		if len(m.Buckets) > 0 {
+			m.bounds = m.Buckets
+		}
+		m.bounds = append([]float64(nil), m.bounds...)
+	}
+	return m.bounds
+}
+
+// series returns the series of a metric, or nil if name is used by another kind of
+// metric. m.mu must be held.
+func (m *MetricsRegistry) series(name string, kind metricKind, labels Labels) *metricSeries {
+	if m.families == nil {
+		m.families = map[string]*metricFamily{}
+	}
+	family, ok := m.families[name]
+	if !ok {
+		family = &metricFamily{kind: kind, series: map[string]*metricSeries{}}
+		m.families[name] = family
+	}
+	if family.kind != kind {
+		return nil
+	}
+	key := formatLabels(labels, "", 0)
+	s, ok := family.series[key]
+	if !ok {
+		s = &metricSeries{labels: labels}
+		family.series[key] = s
+	}
+	return s
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(m.Render())
+}
+
+// Render returns the metrics in the Prometheus text exposition format.
+func (m *MetricsRegistry) Render() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buckets := m.buckets()
+	var b bytes.Buffer
+	for _, name := range names {
+		family := m.families[name]
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, family.kind)
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := family.series[key]
+			if family.kind != histogramKind {
+				fmt.Fprintf(&b, "%s%s %s\n", name, key, formatFloat(s.value))
+				continue
+			}
+			for i, bound := range buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(s.labels, "le", bound), s.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(s.labels, "le", math.Inf(1)), s.counts[len(buckets)])
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, key, formatFloat(s.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, key, s.counts[len(buckets)])
+		}
+	}
+	return b.Bytes()
+}
+
+// formatLabels returns labels in the exposition format, e.g. {host="example.com"},
+// sorted by name. If le is not empty, a label with that name and the value bound is
+// added last.
+func formatLabels(labels Labels, le string, bound float64) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(labels[name])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, le+`="`+formatFloat(bound)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	registry := &MetricsRegistry{Buckets: []float64{0.5, 1}}
+	registry.AddCounter("jobs_total", Labels{"queue": `a "quoted"\name`}, 2)
+	registry.AddCounter("jobs_total", Labels{"queue": "b"}, 1)
+	registry.AddGauge("jobs_waiting", nil, 3)
+	registry.AddGauge("jobs_waiting", nil, -1)
+	registry.ObserveHistogram("job_seconds", Labels{"queue": "b"}, 0.25)
+	registry.ObserveHistogram("job_seconds", Labels{"queue": "b"}, 0.75)
+	registry.ObserveHistogram("job_seconds", Labels{"queue": "b"}, 2)
+	registry.AddGauge("jobs_total", nil, 1) // ignored, because jobs_total is a counter
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Check(t, is.Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type")))
+	assert.Check(t, is.Equal(`# TYPE job_seconds histogram
+job_seconds_bucket{queue="b",le="0.5"} 1
+job_seconds_bucket{queue="b",le="1"} 2
+job_seconds_bucket{queue="b",le="+Inf"} 3
+job_seconds_sum{queue="b"} 3
+job_seconds_count{queue="b"} 3
+# TYPE jobs_total counter
+jobs_total{queue="a \"quoted\"\\name"} 2
+jobs_total{queue="b"} 1
+# TYPE jobs_waiting gauge
+jobs_waiting 2
+`, w.Body.String()))
+
+	// changing the buckets after first use has no effect
+	registry.Buckets = []float64{0.1, 0.2, 0.5, 1}
+	registry.ObserveHistogram("job_seconds", Labels{"queue": "b"}, 0.1)
+	assert.Check(t, is.Contains(string(registry.Render()), `job_seconds_bucket{queue="b",le="0.5"} 2
+job_seconds_bucket{queue="b",le="1"} 3
+job_seconds_bucket{queue="b",le="+Inf"} 4
+`))
+}
+
+func TestMetricsTransport(t *testing.T) {
+	registry := &MetricsRegistry{Buckets: []float64{60}}
+	server := FakeServer(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Path == "/fail" {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusCreated, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	client := http.Client{Transport: MetricsTransport{Next: server, Metrics: registry}}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Post("https://api.example.com/users/1", "application/json", strings.NewReader(`{}`))
+		assert.NilError(t, err)
+	}
+	_, err := client.Get("https://api.example.com/fail")
+	assert.Check(t, is.ErrorContains(err, "connection refused"))
+
+	metrics := string(registry.Render())
+	for _, line := range []string{
+		`httpx_client_requests_total{host="api.example.com",method="POST",status="201"} 2`,
+		`httpx_client_requests_total{host="api.example.com",method="GET",status="error"} 1`,
+		`httpx_client_request_duration_seconds_bucket{host="api.example.com",method="POST",le="60"} 2`,
+		`httpx_client_request_duration_seconds_count{host="api.example.com",method="GET"} 1`,
+		`httpx_client_requests_in_flight{host="api.example.com"} 0`,
+	} {
+		assert.Check(t, is.Contains(metrics, line+"\n"))
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	registry := &MetricsRegistry{Buckets: []float64{60}}
+	mux := goji.NewMux()
+	mux.Use(MetricsMiddleware(registry))
+	mux.HandleFunc(pat.Get("/users/:id"), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.Handle(pat.Get("/metrics"), registry)
+	client := http.Client{Transport: HandlerTransport{Handler: mux}}
+
+	for _, path := range []string{"/users/1", "/users/2", "/nope"} {
+		resp, err := client.Get("https://api.example.com" + path)
+		assert.NilError(t, err)
+		// reading to the end waits for the handler, and so the middleware, to finish
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.NilError(t, err)
+	}
+	for _, method := range []string{"FROB", "GROB"} {
+		r, err := http.NewRequest(method, "https://api.example.com/nope", nil)
+		assert.NilError(t, err)
+		resp, err := client.Do(r)
+		assert.NilError(t, err)
+		// reading to the end waits for the handler, and so the middleware, to finish
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.NilError(t, err)
+	}
+
+	resp, err := client.Get("https://api.example.com/metrics")
+	assert.NilError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	metrics := string(body)
+	for _, line := range []string{
+		`# HELP httpx_server_requests_total Total number of HTTP requests served.`,
+		`# TYPE httpx_server_requests_total counter`,
+		`httpx_server_requests_total{method="GET",pattern="/users/:id",status="204"} 2`,
+		`httpx_server_requests_total{method="GET",pattern="none",status="404"} 1`,
+		`httpx_server_requests_total{method="OTHER",pattern="none",status="404"} 2`,
+		`httpx_server_request_duration_seconds_count{method="GET",pattern="/users/:id"} 2`,
+		`httpx_server_requests_in_flight 1`,
+	} {
+		assert.Check(t, is.Contains(metrics, line+"\n"))
+	}
+	assert.Check(t, !strings.Contains(metrics, "/users/1"))
+	assert.Check(t, !strings.Contains(metrics, "FROB"))
+}
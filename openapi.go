@@ -0,0 +1,581 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goji.io"
+	"goji.io/pat"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// Router is the interface of a goji mux used by Registry.Handle. It is implemented by *goji.Mux.
+type Router interface {
+	Handle(p goji.Pattern, h http.Handler)
+}
+
+// Operation describes an endpoint recorded by a Registry.
+type Operation struct {
+	// Method is the HTTP method, e.g. "POST".
+	Method string
+
+	// Pattern is a goji pat pattern, e.g. "/users/:id".
+	Pattern string
+
+	Summary string
+
+	// Errors lists the error status codes that the endpoint may return.
+	Errors []int
+
+	// Input and Output are the types of the request and response. They are filled in
+	// automatically for handlers created by JSONHandler and its generic variants. Middleware
+	// that wraps such a handler hides its types, so they must be set explicitly, unless the
+	// middleware is from this package and documented to preserve them.
+	Input  reflect.Type
+	Output reflect.Type
+}
+
+// Registry records the endpoints of an API so that they can be described by an
+// OpenAPI 3 document. The zero value is ready to use.
+//
+// Example usage:
+//
+//   api := &httpx.Registry{Title: "Example API", Version: "1.0"}
+//   api.Handle(mux, httpx.Operation{Method: "GET", Pattern: "/users/:id", Errors: []int{404}},
+//      httpx.JSONHandlerFunc(getUser))
+//   mux.Handle(pat.Get("/openapi.json"), api)
+//   mux.Handle(pat.Get("/openapi.yaml"), api)
+//
+type Registry struct {
+	Title   string
+	Version string
+
+	mu         sync.Mutex
+	operations []Operation
+}
+
+// Handle records op and registers h with mux for op.Method and op.Pattern.
+func (reg *Registry) Handle(mux Router, op Operation, h http.Handler) {
+	if jt, ok := h.(jsonTyper); ok {
+		in, out := jt.jsonTypes()
+		if op.Input == nil {
+			op.Input = in
+		}
+		if op.Output == nil {
+			op.Output = out
+		}
+	}
+
+	reg.mu.Lock()
+	reg.operations = append(reg.operations, op)
+	reg.mu.Unlock()
+
+	methods := []string{op.Method}
+	if op.Method == http.MethodGet {
+		methods = append(methods, http.MethodHead)
+	}
+	mux.Handle(pat.NewWithMethods(op.Pattern, methods...), h)
+}
+
+// Operations returns the operations recorded by Handle.
+func (reg *Registry) Operations() []Operation {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return append([]Operation(nil), reg.operations...)
+}
+
+// Document returns the OpenAPI 3 document describing the recorded operations. Schemas
+// are derived from the struct fields and json tags of the input and output types, and
+// the constraints of their validate tags.
+func (reg *Registry) Document() map[string]interface{} {
+	gen := schemaGenerator{schemas: map[string]interface{}{}, names: map[reflect.Type]string{}}
+
+	paths := map[string]interface{}{}
+	for _, op := range reg.Operations() {
+		path := openAPIPath(op.Pattern)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = gen.operation(op)
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   reg.Title,
+			"version": reg.Version,
+		},
+		"paths": paths,
+	}
+	if len(gen.schemas) > 0 {
+		doc["components"] = map[string]interface{}{"schemas": gen.schemas}
+	}
+	return doc
+}
+
+// MarshalJSON returns the OpenAPI document in JSON format.
+func (reg *Registry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(reg.Document())
+}
+
+// MarshalYAML returns the OpenAPI document in YAML format.
+func (reg *Registry) MarshalYAML() ([]byte, error) {
+	buf, err := json.Marshal(reg.Document())
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	writeYAML(out, doc, 0)
+	return out.Bytes(), nil
+}
+
+// ServeHTTP serves the OpenAPI document. The document is YAML if the request path ends
+// in .yaml or .yml, or the request accepts application/yaml, and JSON otherwise.
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, ".yaml") || strings.HasSuffix(r.URL.Path, ".yml") ||
+		strings.Contains(r.Header.Get("Accept"), "yaml") {
+		buf, err := reg.MarshalYAML()
+		if err != nil {
+			httperr.ReportError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(buf)
+		return
+	}
+
+	buf, err := reg.MarshalJSON()
+	if err != nil {
+		httperr.ReportError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(buf)
+}
+
+var patternVariableRE = regexp.MustCompile(`:([^/.;,]+)`)
+
+// openAPIPath converts a goji pat pattern to an OpenAPI path template, e.g.
+// "/users/:id" becomes "/users/{id}".
+func openAPIPath(pattern string) string {
+	pattern = strings.TrimSuffix(pattern, "*")
+	return patternVariableRE.ReplaceAllString(pattern, "{$1}")
+}
+
+type schemaGenerator struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+func (gen *schemaGenerator) operation(op Operation) map[string]interface{} {
+	result := map[string]interface{}{}
+	if op.Summary != "" {
+		result["summary"] = op.Summary
+	}
+
+	var parameters []interface{}
+	pathParams := map[string]bool{}
+	in := op.Input
+	for in != nil && in.Kind() == reflect.Ptr {
+		in = in.Elem()
+	}
+	if in != nil && in.Kind() == reflect.Struct {
+		for _, b := range bindingsOf(in) {
+			field := in.FieldByIndex(b.index)
+			param := map[string]interface{}{
+				"name":   b.name,
+				"in":     b.source,
+				"schema": gen.fieldSchema(field),
+			}
+			if b.source == "path" {
+				pathParams[b.name] = true
+				param["required"] = true
+			} else if hasRequiredRule(field) {
+				param["required"] = true
+			}
+			parameters = append(parameters, param)
+		}
+	}
+	for _, m := range patternVariableRE.FindAllStringSubmatch(op.Pattern, -1) {
+		if !pathParams[m[1]] {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		result["parameters"] = parameters
+	}
+
+	if in != nil && (in.Kind() != reflect.Struct || hasJSONFields(in)) {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": gen.schema(in)},
+			},
+		}
+	}
+
+	responses := map[string]interface{}{}
+	if op.Output != nil {
+		responses["200"] = map[string]interface{}{
+			"description": http.StatusText(http.StatusOK),
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": gen.schema(op.Output)},
+			},
+		}
+	} else {
+		responses["204"] = map[string]interface{}{"description": http.StatusText(http.StatusNoContent)}
+	}
+	for _, code := range op.Errors {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": http.StatusText(code),
+			"content": map[string]interface{}{
+				httperr.ProblemContentType: map[string]interface{}{"schema": gen.problemSchema()},
+			},
+		}
+	}
+	result["responses"] = responses
+	return result
+}
+
+func (gen *schemaGenerator) problemSchema() map[string]interface{} {
+	if _, ok := gen.schemas["Problem"]; !ok {
+		gen.schemas["Problem"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"type":     map[string]interface{}{"type": "string"},
+				"title":    map[string]interface{}{"type": "string"},
+				"status":   map[string]interface{}{"type": "integer"},
+				"detail":   map[string]interface{}{"type": "string"},
+				"instance": map[string]interface{}{"type": "string"},
+			},
+		}
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/Problem"}
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema returns the JSON schema of t. Named struct types are added to the
+// components and referenced.
+func (gen *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return map[string]interface{}{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": gen.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": gen.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return gen.structSchema(t)
+		}
+		name, ok := gen.names[t]
+		if !ok {
+			name = gen.componentName(t)
+			gen.names[t] = name
+			gen.schemas[name] = map[string]interface{}{} // placeholder for recursive types
+			gen.schemas[name] = gen.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// componentName returns a unique component name for the named type t.
+func (gen *schemaGenerator) componentName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i] // generic type arguments are not valid in component names
+	}
+	if _, taken := gen.schemas[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	candidate := pkg + "." + name
+	for i := 2; ; i++ {
+		if _, taken := gen.schemas[candidate]; !taken {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s.%s%d", pkg, name, i)
+	}
+}
+
+func (gen *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	gen.addProperties(t, properties, &required)
+
+	result := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		result["required"] = required
+	}
+	return result
+}
+
+func (gen *schemaGenerator) addProperties(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue // unexported
+		}
+		name, inJSON := jsonFieldName(field)
+		if !inJSON {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				gen.addProperties(embedded, properties, required)
+				continue
+			}
+			name = embedded.Name()
+		}
+
+		properties[name] = gen.fieldSchema(field)
+		if hasRequiredRule(field) {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// fieldSchema returns the schema of field including the constraints of its validate tag.
+func (gen *schemaGenerator) fieldSchema(field reflect.StructField) map[string]interface{} {
+	s := gen.schema(field.Type)
+	if _, isRef := s["$ref"]; isRef {
+		return s
+	}
+
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, rule := range parseRules(field.Tag.Get("validate")) {
+		n, err := strconv.ParseFloat(rule.arg, 64)
+		isNumber := err == nil
+		switch {
+		case rule.name == "oneof":
+			var enum []interface{}
+			for _, option := range strings.Fields(rule.arg) {
+				if v, err := strconv.ParseFloat(option, 64); err == nil && s["type"] != "string" {
+					enum = append(enum, v)
+				} else {
+					enum = append(enum, option)
+				}
+			}
+			s["enum"] = enum
+		case rule.name == "pattern":
+			s["pattern"] = rule.arg
+		case !isNumber:
+		case s["type"] == "string":
+			if rule.name == "min" || rule.name == "len" {
+				s["minLength"] = n
+			}
+			if rule.name == "max" || rule.name == "len" {
+				s["maxLength"] = n
+			}
+		case s["type"] == "array":
+			if rule.name == "min" || rule.name == "len" {
+				s["minItems"] = n
+			}
+			if rule.name == "max" || rule.name == "len" {
+				s["maxItems"] = n
+			}
+		case s["type"] == "object":
+			if rule.name == "min" || rule.name == "len" {
+				s["minProperties"] = n
+			}
+			if rule.name == "max" || rule.name == "len" {
+				s["maxProperties"] = n
+			}
+		case rule.name == "min":
+			s["minimum"] = n
+		case rule.name == "max":
+			s["maximum"] = n
+		}
+	}
+	return s
+}
+
+func hasRequiredRule(field reflect.StructField) bool {
+	for _, rule := range parseRules(field.Tag.Get("validate")) {
+		if rule.name == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasJSONFields returns true if the struct type t has any fields that are decoded from the JSON body.
+func hasJSONFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name, inJSON := jsonFieldName(field)
+		if !inJSON {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() != reflect.Struct || hasJSONFields(embedded) {
+				return true
+			}
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+var plainYAMLKeyRE = regexp.MustCompile(`^[A-Za-z_/$][A-Za-z0-9_./${}-]*$`)
+
+// writeYAML writes v, a value decoded from JSON, to buf as block-style YAML indented
+// by indent spaces. Map keys are sorted and strings are always quoted.
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int) {
+	prefix := strings.Repeat(" ", indent)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString(prefix + "{}\n")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := k
+			if !plainYAMLKeyRE.MatchString(k) {
+				key = yamlScalar(k)
+			}
+			if isYAMLCollection(v[k]) {
+				buf.WriteString(prefix + key + ":\n")
+				writeYAML(buf, v[k], indent+2)
+			} else {
+				buf.WriteString(prefix + key + ": " + yamlScalar(v[k]) + "\n")
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(prefix + "[]\n")
+			return
+		}
+		for _, item := range v {
+			if !isYAMLCollection(item) {
+				buf.WriteString(prefix + "- " + yamlScalar(item) + "\n")
+				continue
+			}
+			// render the item indented, then replace the indentation of its first line with "- "
+			nested := &bytes.Buffer{}
+			writeYAML(nested, item, indent+2)
+			buf.WriteString(prefix + "- ")
+			buf.Write(nested.Bytes()[indent+2:])
+		}
+	default:
+		buf.WriteString(prefix + yamlScalar(v) + "\n")
+	}
+}
+
+func isYAMLCollection(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"goji.io"
+	"goji.io/pat"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+type Widget struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name" validate:"required,max=64"`
+	Color     string    `json:"color,omitempty" validate:"oneof=red green"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"created_at"`
+	internal  string
+}
+
+type GetWidgetInput struct {
+	ID      string `path:"id" json:"-"`
+	Verbose bool   `query:"verbose" json:"-"`
+}
+
+type UpdateWidgetInput struct {
+	ID string `path:"id" json:"-"`
+	Widget
+}
+
+func TestRegistry(t *testing.T) {
+	mux := goji.NewMux()
+	api := &Registry{Title: "Widgets", Version: "1.2.3"}
+	api.Handle(mux, Operation{Method: "GET", Pattern: "/widgets/:id", Summary: "Get a widget", Errors: []int{404}},
+		JSONHandlerFunc(func(r *http.Request, in GetWidgetInput) (*Widget, error) {
+			return &Widget{ID: in.ID, Name: "frob"}, nil
+		}))
+	api.Handle(mux, Operation{Method: "PUT", Pattern: "/widgets/:id"},
+		JSONHandler(func(r *http.Request, in *UpdateWidgetInput) error {
+			return nil
+		}))
+	mux.Handle(pat.Get("/openapi.json"), api)
+	mux.Handle(pat.Get("/openapi.yaml"), api)
+
+	t.Run("routes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/widgets/w1", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Check(t, is.Contains(w.Body.String(), `"id":"w1"`))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var doc map[string]interface{}
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+
+		expected := `{
+		  "components": {"schemas": {
+		    "Problem": {"type": "object", "properties": {
+		      "detail": {"type": "string"}, "instance": {"type": "string"}, "status": {"type": "integer"},
+		      "title": {"type": "string"}, "type": {"type": "string"}}},
+		    "UpdateWidgetInput": {"type": "object", "required": ["name"], "properties": {
+		      "id": {"type": "string"},
+		      "name": {"type": "string", "maxLength": 64},
+		      "color": {"type": "string", "enum": ["red", "green"]},
+		      "tags": {"type": "array", "items": {"type": "string"}},
+		      "created_at": {"type": "string", "format": "date-time"}}},
+		    "Widget": {"type": "object", "required": ["name"], "properties": {
+		      "id": {"type": "string"},
+		      "name": {"type": "string", "maxLength": 64},
+		      "color": {"type": "string", "enum": ["red", "green"]},
+		      "tags": {"type": "array", "items": {"type": "string"}},
+		      "created_at": {"type": "string", "format": "date-time"}}}
+		  }},
+		  "info": {"title": "Widgets", "version": "1.2.3"},
+		  "openapi": "3.0.3",
+		  "paths": {"/widgets/{id}": {
+		    "get": {
+		      "summary": "Get a widget",
+		      "parameters": [
+		        {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}},
+		        {"name": "verbose", "in": "query", "schema": {"type": "boolean"}}
+		      ],
+		      "responses": {
+		        "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Widget"}}}},
+		        "404": {"description": "Not Found", "content": {"application/problem+json": {"schema": {"$ref": "#/components/schemas/Problem"}}}}
+		      }
+		    },
+		    "put": {
+		      "parameters": [
+		        {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}
+		      ],
+		      "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UpdateWidgetInput"}}}},
+		      "responses": {"204": {"description": "No Content"}}
+		    }
+		  }}
+		}`
+		var expectedDoc map[string]interface{}
+		assert.NilError(t, json.Unmarshal([]byte(expected), &expectedDoc))
+		assert.DeepEqual(t, expectedDoc, doc)
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.yaml", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+		assert.Check(t, is.Contains(w.Body.String(), `
+paths:
+  /widgets/{id}:
+    get:
+      parameters:
+        - in: "path"
+          name: "id"
+          required: true
+          schema:
+            type: "string"
+        - in: "query"
+          name: "verbose"
+          schema:
+            type: "boolean"
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Widget"
+`))
+	})
+}
+
+// typedMiddleware wraps a handler and preserves its JSON types, as middleware in this
+// package may.
+type typedMiddleware struct {
+	http.Handler
+}
+
+func (m typedMiddleware) jsonTypes() (in, out reflect.Type) {
+	return m.Handler.(jsonTyper).jsonTypes()
+}
+
+func TestRegistryWrappedHandler(t *testing.T) {
+	getWidget := JSONHandlerFunc(func(r *http.Request, in GetWidgetInput) (*Widget, error) {
+		return &Widget{ID: in.ID}, nil
+	})
+	opaque := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	mux := goji.NewMux()
+	api := &Registry{}
+	api.Handle(mux, Operation{Method: "GET", Pattern: "/a/:id"}, typedMiddleware{getWidget})
+	api.Handle(mux, Operation{Method: "GET", Pattern: "/b/:id"}, opaque(getWidget))
+	api.Handle(mux, Operation{Method: "GET", Pattern: "/c/:id", Input: typeOf[GetWidgetInput](), Output: typeOf[Widget]()},
+		opaque(getWidget))
+
+	ops := api.Operations()
+	assert.Equal(t, len(ops), 3)
+	assert.Check(t, is.Equal(typeOf[GetWidgetInput](), ops[0].Input))
+	assert.Check(t, is.Equal(typeOf[Widget](), ops[0].Output))
+	assert.Check(t, is.Nil(ops[1].Input))
+	assert.Check(t, is.Nil(ops[1].Output))
+	assert.Check(t, is.Equal(typeOf[GetWidgetInput](), ops[2].Input))
+	assert.Check(t, is.Equal(typeOf[Widget](), ops[2].Output))
+}
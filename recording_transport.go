@@ -0,0 +1,317 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"unicode/utf8"
+)
+
+// RecordingMode selects whether a RecordingTransport records or replays interactions.
+type RecordingMode int
+
+const (
+	// ModeReplay serves responses from the cassette without making real requests.
+	ModeReplay RecordingMode = iota
+
+	// ModeRecord makes real requests using Next and writes them to the cassette.
+	ModeRecord
+)
+
+// redacted replaces the values of redacted headers in a cassette.
+const redacted = "REDACTED"
+
+// Cassette is the on-disk format of the interactions recorded by a RecordingTransport.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request stored in a Cassette.
+type RecordedRequest struct {
+	Method string       `json:"method"`
+	URL    string       `json:"url"`
+	Header http.Header  `json:"header,omitempty"`
+	Body   RecordedBody `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response stored in a Cassette.
+type RecordedResponse struct {
+	StatusCode int          `json:"status_code"`
+	Header     http.Header  `json:"header,omitempty"`
+	Body       RecordedBody `json:"body,omitempty"`
+}
+
+// RecordedBody is a request or response body. It is stored as a string if it is
+// valid UTF-8, and as base64 otherwise.
+type RecordedBody []byte
+
+// MarshalJSON implements json.Marshaler
+func (b RecordedBody) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *RecordedBody) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err == nil {
+		*b = RecordedBody(s)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(buf, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// RecordingTransport is an http.RoundTripper that records interactions to a cassette
+// file, or replays them from it, so that tests of API clients can run without network
+// access.
+//
+// In ModeRecord, each request is sent using Next and the interaction is appended to
+// the cassette file at Path. The first interaction replaces any existing cassette, so
+// that re-recording does not keep stale interactions. Authorization and Proxy-Authorization headers (e.g. set
+// by BasicAuthTransport), any headers listed in RedactHeaders, and credentials in the
+// URL are redacted before writing.
+//
+// In ModeReplay, each request is answered with the response of the first unused
+// recorded interaction that matches it. Requests match if their method and URL are
+// equal, and if MatchBody is set, their bodies are equal (JSON bodies are compared
+// semantically), and the headers listed in MatchHeaders are equal. Once every matching
+// interaction has been used, the last one is reused.
+//
+// e.g.
+//
+//   transport := &RecordingTransport{Path: "testdata/users.json", Mode: ModeReplay}
+//   c := JSONClient{Client: &http.Client{Transport: transport}}
+//
+type RecordingTransport struct {
+	Next          http.RoundTripper
+	Path          string
+	Mode          RecordingMode
+	MatchBody     bool
+	MatchHeaders  []string
+	RedactHeaders []string
+
+	mu       sync.Mutex
+	loaded   bool
+	cassette Cassette
+	used     []bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RecordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Mode == ModeRecord {
+		return t.record(r, reqBody)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.loaded {
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+	}
+	return t.replay(r, reqBody)
+}
+
+func (t *RecordingTransport) load() error {
+	buf, err := ioutil.ReadFile(t.Path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, &t.cassette); err != nil {
+		return fmt.Errorf("%s: %w", t.Path, err)
+	}
+	t.used = make([]bool, len(t.cassette.Interactions))
+	t.loaded = true
+	return nil
+}
+
+func (t *RecordingTransport) record(r *http.Request, reqBody []byte) (*http.Response, error) {
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: r.Method,
+			URL:    redactURL(r.URL),
+			Header: t.redactHeader(r.Header),
+			Body:   reqBody,
+		},
+	}
+
+	resp, err := t.Next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	interaction.Response = RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     t.redactHeader(resp.Header),
+		Body:       respBody,
+	}
+
+	// the lock is held only while updating the cassette, so that concurrent requests
+	// are not serialized
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.cassette.Interactions = append(t.cassette.Interactions, interaction)
+
+	buf, err := json.MarshalIndent(t.cassette, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(t.Path, buf, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *RecordingTransport) replay(r *http.Request, reqBody []byte) (*http.Response, error) {
+	found := -1
+	for i, interaction := range t.cassette.Interactions {
+		if !t.matches(r, reqBody, interaction.Request) {
+			continue
+		}
+		found = i
+		if !t.used[i] {
+			break
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("no recorded interaction in %s matches %s %s", t.Path, r.Method, redactURL(r.URL))
+	}
+	t.used[found] = true
+
+	recorded := t.cassette.Interactions[found].Response
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       r,
+	}, nil
+}
+
+func (t *RecordingTransport) matches(r *http.Request, reqBody []byte, recorded RecordedRequest) bool {
+	if r.Method != recorded.Method || redactURL(r.URL) != recorded.URL {
+		return false
+	}
+	for _, name := range t.MatchHeaders {
+		want := recorded.Header.Values(name)
+		if len(want) == 1 && want[0] == redacted {
+			continue
+		}
+		if !reflect.DeepEqual(want, r.Header.Values(name)) {
+			return false
+		}
+	}
+	if t.MatchBody && !bodiesEqual(reqBody, recorded.Body) {
+		return false
+	}
+	return true
+}
+
+func (t *RecordingTransport) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range append([]string{"Authorization", "Proxy-Authorization"}, t.RedactHeaders...) {
+		if h.Get(name) != "" {
+			h.Set(name, redacted)
+		}
+	}
+	return h
+}
+
+// redactURL returns u as a string with any password removed.
+func redactURL(u *url.URL) string {
+	if _, hasPassword := u.User.Password(); !hasPassword {
+		return u.String()
+	}
+	copied := *u
+	copied.User = url.UserPassword(u.User.Username(), redacted)
+	return copied.String()
+}
+
+// bodiesEqual compares two bodies, semantically if both are JSON.
+func bodiesEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// readBody reads and closes *body, replacing it with a reader of the same content.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	buf, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
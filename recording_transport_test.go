@@ -0,0 +1,163 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "testdata", "cassette.json")
+
+	// record
+	{
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.Check(t, err)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+			resp.Header.Set("Content-Type", "application/json")
+			resp.Body = ioutil.NopCloser(strings.NewReader(`{"Bar": "` + strings.ToUpper(string(reqBody[8:11])) + `"}`))
+			return resp, nil
+		})
+		c := JSONClient{Client: &http.Client{Transport: BasicAuthTransport{
+			Next:     &RecordingTransport{Next: fakeTransport, Path: path, Mode: ModeRecord},
+			Username: "alice",
+			Password: "hunter2",
+		}}}
+
+		resp := ResponseBody{}
+		assert.NilError(t, c.DoJSON(ctx, "POST", "https://api.example.com/foo", RequestBody{Foo: "abc"}, &resp))
+		assert.Equal(t, "ABC", resp.Bar)
+		assert.NilError(t, c.DoJSON(ctx, "POST", "https://api.example.com/foo", RequestBody{Foo: "xyz"}, &resp))
+		assert.Equal(t, "XYZ", resp.Bar)
+
+		cassette, err := ioutil.ReadFile(path)
+		assert.NilError(t, err)
+		var recorded Cassette
+		assert.NilError(t, json.Unmarshal(cassette, &recorded))
+		assert.Equal(t, 2, len(recorded.Interactions))
+		assert.Check(t, is.Equal("REDACTED", recorded.Interactions[0].Request.Header.Get("Authorization")))
+		assert.Check(t, is.Equal(`{"Foo":"abc"}`, string(recorded.Interactions[0].Request.Body)))
+		assert.Check(t, !strings.Contains(string(cassette), "hunter2"))
+		assert.Check(t, !strings.Contains(string(cassette), "YWxpY2U6aHVudGVyMg=="))
+	}
+
+	// replay
+	{
+		c := JSONClient{Client: &http.Client{Transport: &RecordingTransport{
+			Next: FakeServer(func(r *http.Request) (*http.Response, error) {
+				panic("not reached")
+			}),
+			Path:         path,
+			MatchBody:    true,
+			MatchHeaders: []string{"Authorization", "Content-Type"},
+		}}}
+
+		resp := ResponseBody{}
+		assert.NilError(t, c.DoJSON(ctx, "POST", "https://api.example.com/foo", RequestBody{Foo: "xyz"}, &resp))
+		assert.Equal(t, "XYZ", resp.Bar)
+		assert.NilError(t, c.DoJSON(ctx, "POST", "https://api.example.com/foo", RequestBody{Foo: "abc"}, &resp))
+		assert.Equal(t, "ABC", resp.Bar)
+
+		// interactions can be reused
+		assert.NilError(t, c.DoJSON(ctx, "POST", "https://api.example.com/foo", RequestBody{Foo: "abc"}, &resp))
+		assert.Equal(t, "ABC", resp.Bar)
+
+		err := c.DoJSON(ctx, "POST", "https://api.example.com/foo", RequestBody{Foo: "def"}, &resp)
+		assert.Check(t, is.ErrorContains(err, "no recorded interaction in "+path+" matches POST https://api.example.com/foo"))
+	}
+}
+
+func TestRecordingTransportRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recordedURLs := func() []string {
+		buf, err := ioutil.ReadFile(path)
+		assert.NilError(t, err)
+		var cassette Cassette
+		assert.NilError(t, json.Unmarshal(buf, &cassette))
+		var urls []string
+		for _, interaction := range cassette.Interactions {
+			urls = append(urls, interaction.Request.URL)
+		}
+		sort.Strings(urls)
+		return urls
+	}
+
+	t.Run("concurrent", func(t *testing.T) {
+		// each response waits until both requests are in flight
+		var inFlight sync.WaitGroup
+		inFlight.Add(2)
+		bothInFlight := make(chan struct{})
+		go func() {
+			inFlight.Wait()
+			close(bothInFlight)
+		}()
+		c := &http.Client{Transport: &RecordingTransport{
+			Next: FakeServer(func(r *http.Request) (*http.Response, error) {
+				inFlight.Done()
+				select {
+				case <-bothInFlight:
+				case <-time.After(5 * time.Second):
+					return nil, errors.New("requests were serialized")
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{}`))}, nil
+			}),
+			Path: path,
+			Mode: ModeRecord,
+		}}
+
+		var done sync.WaitGroup
+		for _, uri := range []string{"https://api.example.com/a", "https://api.example.com/b"} {
+			done.Add(1)
+			go func(uri string) {
+				defer done.Done()
+				resp, err := c.Get(uri)
+				if assert.Check(t, err) {
+					resp.Body.Close()
+				}
+			}(uri)
+		}
+		done.Wait()
+		assert.DeepEqual(t, []string{"https://api.example.com/a", "https://api.example.com/b"}, recordedURLs())
+	})
+
+	t.Run("replaces cassette", func(t *testing.T) {
+		c := &http.Client{Transport: &RecordingTransport{
+			Next: FakeServer(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{}`))}, nil
+			}),
+			Path: path,
+			Mode: ModeRecord,
+		}}
+		resp, err := c.Get("https://api.example.com/c")
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.DeepEqual(t, []string{"https://api.example.com/c"}, recordedURLs())
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// Recover is middleware that converts panics in next into private 500 errors. The
+// error includes the panic value and a stack trace, and is passed to httperr.ReportError,
+// so it is logged by a hook installed with httperr.OnError, e.g. by AccessLog.
+//
+// If next has already started writing its response, the error is still reported, but
+// nothing more is written to the client. Panics with http.ErrAbortHandler are not
+// recovered, so that the handler can still abort the response.
+//
+// e.g.
+//
+//   mux := goji.NewMux()
+//   mux.Use(AccessLog(slog.Default()))
+//   mux.Use(Recover)
+//
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := WrapResponseWriter(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			err, ok := p.(error)
+			if ok {
+				err = errors.WithStack(err)
+			} else {
+				err = errors.Errorf("%v", p)
+			}
+			err = httperr.Wrap(http.StatusInternalServerError, errors.WithMessage(err, "panic"))
+			httperr.ReportError(tw, r, err)
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
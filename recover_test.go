@@ -0,0 +1,80 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+func TestRecover(t *testing.T) {
+	handler := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/started" {
+			_, _ = w.Write([]byte("partial"))
+		}
+		if r.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		panic("cannot frob the grob")
+	}))
+
+	t.Run("not started", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Check(t, is.Equal(http.StatusInternalServerError, w.Code))
+		assert.Check(t, is.Equal("Internal Server Error\n", w.Body.String()))
+		assert.Check(t, is.Equal("", w.Header().Get("X-Error-Message")))
+	})
+
+	t.Run("reported", func(t *testing.T) {
+		var reported error
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r = httperr.OnError(r, func(err error) { reported = err })
+		handler.ServeHTTP(w, r)
+
+		assert.Assert(t, reported != nil)
+		assert.Check(t, is.Equal("panic: cannot frob the grob", reported.Error()))
+		assert.Check(t, is.Equal(http.StatusInternalServerError, httperr.StatusCode(reported)))
+		assert.Check(t, !httperr.IsPublic(reported))
+		assert.Check(t, is.Contains(fmt.Sprintf("%+v", reported), "recover_test.go"))
+	})
+
+	t.Run("started", func(t *testing.T) {
+		logger := &testLogger{}
+		w := httptest.NewRecorder()
+		AccessLog(logger)(handler).ServeHTTP(w, httptest.NewRequest("GET", "/started", nil))
+		assert.Check(t, is.Equal(http.StatusOK, w.Code))
+		assert.Check(t, is.Equal("partial", w.Body.String()))
+
+		assert.Assert(t, is.Len(logger.records, 1))
+		assert.Check(t, is.Equal("error", logger.records[0].Level))
+		assert.Check(t, is.Equal("panic: cannot frob the grob", logger.records[0].Attrs["error"]))
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		defer func() {
+			assert.Check(t, is.Equal(http.ErrAbortHandler, recover()))
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// RequestIDHeader is the header that holds the request ID.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest incoming request ID that is accepted.
+const maxRequestIDLength = 128
+
+type requestIDIndexType int
+
+const requestIDIndex requestIDIndexType = iota
+
+// ContextWithRequestID returns a new context that holds the request ID id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDIndex, id)
+}
+
+// RequestIDFromContext returns the request ID held by ctx, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDIndex).(string)
+	return id
+}
+
+// RequestID is middleware that gives each request an ID, which is stored in the request
+// context and sent back in the X-Request-ID response header.
+//
+// The ID is taken from the X-Request-ID request header if it is present, printable ASCII
+// and at most 128 characters long. Otherwise a random ID is generated. Errors reported
+// with httperr.ReportError are wrapped so that RequestIDFromError returns the ID.
+//
+// e.g.
+//
+//   mux := goji.NewMux()
+//   mux.Use(RequestID)
+//   mux.Use(AccessLog(slog.Default()))
+//
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		r = r.WithContext(ContextWithRequestID(r.Context(), id))
+		r = httperr.MapError(r, func(err error) error {
+			return requestIDError{err: err, requestID: id}
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestIDTransport is an http.RoundTripper that sends the request ID of the request
+// context, if there is one, in the X-Request-ID header, so that the ID assigned by
+// RequestID is forwarded to other services.
+//
+// e.g.
+//
+//   c := JSONClient{Client: &http.Client{Transport: RequestIDTransport{Next: http.DefaultTransport}}}
+//
+type RequestIDTransport struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t RequestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	id := RequestIDFromContext(r.Context())
+	if id == "" || r.Header.Get(RequestIDHeader) != "" {
+		return t.Next.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set(RequestIDHeader, id)
+	return t.Next.RoundTrip(r)
+}
+
+// RequestIDFromError returns the ID of the request that err was reported for, or "" if
+// it is not known.
+func RequestIDFromError(err error) string {
+	var e requestIDError
+	if errors.As(err, &e) {
+		return e.requestID
+	}
+	return ""
+}
+
+// requestIDError is an error reported while serving a request with a known ID.
+type requestIDError struct {
+	err       error
+	requestID string
+}
+
+func (e requestIDError) Error() string {
+	return e.err.Error()
+}
+
+func (e requestIDError) Unwrap() error {
+	return e.err
+}
+
+// Cause lets github.com/pkg/errors.Cause, which httperr.Write uses to find the public
+// message of an error, see through the request ID.
+func (e requestIDError) Cause() error {
+	return e.err
+}
+
+func (e requestIDError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			fmt.Fprintf(s, "%+v\nrequest id: %s", e.err, e.requestID)
+			return
+		}
+		fallthrough
+	case 's':
+		_, _ = io.WriteString(s, e.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	}
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var buf [16]byte
+	mustReadRandom(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+func TestRequestID(t *testing.T) {
+	var forwarded string
+	upstream := FakeServer(func(r *http.Request) (*http.Response, error) {
+		forwarded = r.Header.Get(RequestIDHeader)
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+	})
+	client := JSONClient{Client: &http.Client{Transport: RequestIDTransport{Next: upstream}}}
+
+	var seen string
+	handler := RequestID(httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		seen = RequestIDFromContext(r.Context())
+		if err := client.DoJSON(r.Context(), "GET", "https://upstream.example.com/", nil, &struct{}{}); err != nil {
+			return err
+		}
+		switch r.URL.Path {
+		case "/fail":
+			return httperr.Publicf(http.StatusConflict, "cannot frob the grob")
+		case "/wrapped":
+			return errors.Wrap(httperr.Publicf(http.StatusBadRequest, "bad widget"), "internal db context secret")
+		}
+		return nil
+	}))
+
+	t.Run("incoming", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(RequestIDHeader, "req-123")
+		handler.ServeHTTP(w, r)
+		assert.Check(t, is.Equal("req-123", seen))
+		assert.Check(t, is.Equal("req-123", w.Header().Get(RequestIDHeader)))
+		assert.Check(t, is.Equal("req-123", forwarded))
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		for _, incoming := range []string{"", "has space", strings.Repeat("x", 129)} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(RequestIDHeader, incoming)
+			handler.ServeHTTP(w, r)
+			assert.Check(t, is.Len(seen, 32))
+			assert.Check(t, seen != incoming)
+			assert.Check(t, is.Equal(seen, w.Header().Get(RequestIDHeader)))
+			assert.Check(t, is.Equal(seen, forwarded))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		var reported error
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/fail", nil)
+		r.Header.Set(RequestIDHeader, "req-456")
+		r = httperr.OnError(r, func(err error) {
+			reported = err
+			httperr.Write(w, r, err)
+		})
+		handler.ServeHTTP(w, r)
+
+		assert.Check(t, is.Equal(http.StatusConflict, w.Code))
+		assert.Check(t, is.Equal("cannot frob the grob", w.Header().Get("X-Error-Message")))
+		assert.Check(t, is.Equal("req-456", RequestIDFromError(reported)))
+		assert.Check(t, is.Equal(http.StatusConflict, httperr.StatusCode(reported)))
+		assert.Check(t, httperr.IsPublic(reported))
+		assert.Check(t, is.Equal("cannot frob the grob", fmt.Sprint(reported)))
+		assert.Check(t, is.Contains(fmt.Sprintf("%+v", reported), "request id: req-456"))
+	})
+
+	t.Run("wrapped public error", func(t *testing.T) {
+		for _, accept := range []string{"", "application/json"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/wrapped", nil)
+			r.Header.Set("Accept", accept)
+			handler.ServeHTTP(w, r)
+
+			assert.Check(t, is.Equal(http.StatusBadRequest, w.Code))
+			assert.Check(t, !strings.Contains(w.Body.String(), "secret"), w.Body.String())
+			assert.Check(t, !strings.Contains(w.Header().Get("X-Error-Message"), "secret"))
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/wrapped", nil))
+		assert.Check(t, is.Equal("bad widget", w.Header().Get("X-Error-Message")))
+	})
+
+	t.Run("access log", func(t *testing.T) {
+		logger := &testLogger{}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/fail", nil)
+		r.Header.Set(RequestIDHeader, "req-789")
+		RequestID(AccessLog(logger)(handler)).ServeHTTP(w, r)
+
+		assert.Check(t, is.Equal(http.StatusConflict, w.Code))
+		assert.Assert(t, is.Len(logger.records, 1))
+		assert.Check(t, is.Equal("req-789", logger.records[0].Attrs["request_id"]))
+	})
+
+	t.Run("transport without id", func(t *testing.T) {
+		forwarded = "unset"
+		err := client.DoJSON(context.Background(), "GET", "https://upstream.example.com/", nil, &struct{}{})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal("", forwarded))
+	})
+}
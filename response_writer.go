@@ -0,0 +1,247 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// TrackingResponseWriter is an http.ResponseWriter that records the status code and the
+// number of bytes written, and whether the response has been committed, i.e. whether the
+// header has been sent.
+//
+// httperr.Write does not write anything to a committed TrackingResponseWriter.
+type TrackingResponseWriter interface {
+	http.ResponseWriter
+
+	// Status returns the status code sent, or zero if the response is not committed.
+	Status() int
+
+	// BytesWritten returns the number of bytes of the body written.
+	BytesWritten() int64
+
+	// Committed returns true once the header has been sent.
+	Committed() bool
+
+	// Unwrap returns the wrapped http.ResponseWriter. It is used by
+	// http.ResponseController.
+	Unwrap() http.ResponseWriter
+}
+
+// WrapResponseWriter returns a TrackingResponseWriter that wraps w. The result implements
+// each of http.Flusher, http.Hijacker, io.ReaderFrom and http.Pusher only if w does. If w
+// is already a TrackingResponseWriter, it is returned unchanged.
+//
+// e.g.
+//
+//   func(w http.ResponseWriter, r *http.Request) {
+//      tw := WrapResponseWriter(w)
+//      next.ServeHTTP(tw, r)
+//      log.Printf("%s %s %d", r.Method, r.URL.Path, tw.Status())
+//   }
+//
+func WrapResponseWriter(w http.ResponseWriter) TrackingResponseWriter {
+	if tw, ok := w.(TrackingResponseWriter); ok {
+		return tw
+	}
+
+	t := &trackingWriter{w: w}
+	_, isFlusher := w.(http.Flusher)
+	_, isHijacker := w.(http.Hijacker)
+	_, isReaderFrom := w.(io.ReaderFrom)
+	_, isPusher := w.(http.Pusher)
+
+	f, h, rf, p := trackingFlusher{t}, trackingHijacker{t}, trackingReaderFrom{t}, trackingPusher{t}
+	switch {
+	case isFlusher && isHijacker && isReaderFrom && isPusher:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingHijacker
+			trackingReaderFrom
+			trackingPusher
+		}{t, f, h, rf, p}
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingHijacker
+			trackingReaderFrom
+		}{t, f, h, rf}
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingHijacker
+			trackingPusher
+		}{t, f, h, p}
+	case isFlusher && isReaderFrom && isPusher:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingReaderFrom
+			trackingPusher
+		}{t, f, rf, p}
+	case isHijacker && isReaderFrom && isPusher:
+		return struct {
+			*trackingWriter
+			trackingHijacker
+			trackingReaderFrom
+			trackingPusher
+		}{t, h, rf, p}
+	case isFlusher && isHijacker:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingHijacker
+		}{t, f, h}
+	case isFlusher && isReaderFrom:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingReaderFrom
+		}{t, f, rf}
+	case isFlusher && isPusher:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+			trackingPusher
+		}{t, f, p}
+	case isHijacker && isReaderFrom:
+		return struct {
+			*trackingWriter
+			trackingHijacker
+			trackingReaderFrom
+		}{t, h, rf}
+	case isHijacker && isPusher:
+		return struct {
+			*trackingWriter
+			trackingHijacker
+			trackingPusher
+		}{t, h, p}
+	case isReaderFrom && isPusher:
+		return struct {
+			*trackingWriter
+			trackingReaderFrom
+			trackingPusher
+		}{t, rf, p}
+	case isFlusher:
+		return struct {
+			*trackingWriter
+			trackingFlusher
+		}{t, f}
+	case isHijacker:
+		return struct {
+			*trackingWriter
+			trackingHijacker
+		}{t, h}
+	case isReaderFrom:
+		return struct {
+			*trackingWriter
+			trackingReaderFrom
+		}{t, rf}
+	case isPusher:
+		return struct {
+			*trackingWriter
+			trackingPusher
+		}{t, p}
+	}
+	return t
+}
+
+// trackingWriter implements TrackingResponseWriter. The optional interfaces are
+// implemented by separate types, so that WrapResponseWriter can embed only those that
+// the wrapped writer supports.
+type trackingWriter struct {
+	w      http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (t *trackingWriter) Header() http.Header {
+	return t.w.Header()
+}
+
+func (t *trackingWriter) WriteHeader(statusCode int) {
+	// informational responses, e.g. 103 Early Hints, do not commit the response
+	if t.status == 0 && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		t.status = statusCode
+	}
+	t.w.WriteHeader(statusCode)
+}
+
+func (t *trackingWriter) Write(buf []byte) (int, error) {
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+	n, err := t.w.Write(buf)
+	t.bytes += int64(n)
+	return n, err
+}
+
+func (t *trackingWriter) Status() int {
+	return t.status
+}
+
+func (t *trackingWriter) BytesWritten() int64 {
+	return t.bytes
+}
+
+func (t *trackingWriter) Committed() bool {
+	return t.status != 0
+}
+
+func (t *trackingWriter) Unwrap() http.ResponseWriter {
+	return t.w
+}
+
+type trackingFlusher struct{ t *trackingWriter }
+
+func (f trackingFlusher) Flush() {
+	if f.t.status == 0 {
+		f.t.status = http.StatusOK
+	}
+	f.t.w.(http.Flusher).Flush()
+}
+
+type trackingHijacker struct{ t *trackingWriter }
+
+func (h trackingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.t.w.(http.Hijacker).Hijack()
+	if err == nil && h.t.status == 0 {
+		h.t.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+type trackingReaderFrom struct{ t *trackingWriter }
+
+func (rf trackingReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	if rf.t.status == 0 {
+		rf.t.status = http.StatusOK
+	}
+	n, err := rf.t.w.(io.ReaderFrom).ReadFrom(src)
+	rf.t.bytes += n
+	return n, err
+}
+
+type trackingPusher struct{ t *trackingWriter }
+
+func (p trackingPusher) Push(target string, opts *http.PushOptions) error {
+	return p.t.w.(http.Pusher).Push(target, opts)
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// optionalWriter is an http.ResponseWriter that implements the optional interfaces
+// selected by its fields.
+type optionalWriter struct {
+	http.ResponseWriter
+	flusher, hijacker, readerFrom, pusher bool
+}
+
+func (w optionalWriter) build() http.ResponseWriter {
+	var rw http.ResponseWriter = w.ResponseWriter
+	if w.flusher {
+		rw = struct {
+			http.ResponseWriter
+			http.Flusher
+		}{rw, flushFunc(func() {})}
+	}
+	if w.hijacker {
+		rw = struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{rw, hijackFunc(func() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil })}
+	}
+	if w.readerFrom {
+		rw = struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{rw, readerFromFunc(func(src io.Reader) (int64, error) { return io.Copy(w.ResponseWriter, src) })}
+	}
+	if w.pusher {
+		rw = struct {
+			http.ResponseWriter
+			http.Pusher
+		}{rw, pushFunc(func(string, *http.PushOptions) error { return nil })}
+	}
+	return rw
+}
+
+type flushFunc func()
+
+func (f flushFunc) Flush() { f() }
+
+type hijackFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func (f hijackFunc) Hijack() (net.Conn, *bufio.ReadWriter, error) { return f() }
+
+type readerFromFunc func(io.Reader) (int64, error)
+
+func (f readerFromFunc) ReadFrom(src io.Reader) (int64, error) { return f(src) }
+
+type pushFunc func(string, *http.PushOptions) error
+
+func (f pushFunc) Push(target string, opts *http.PushOptions) error { return f(target, opts) }
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	// build returns nested wrappers, so each combination is checked on a plain
+	// http.ResponseWriter that has exactly the selected methods.
+	for i := 0; i < 16; i++ {
+		ow := optionalWriter{
+			ResponseWriter: httptest.NewRecorder(),
+			flusher:        i&1 != 0,
+			hijacker:       i&2 != 0,
+			readerFrom:     i&4 != 0,
+			pusher:         i&8 != 0,
+		}
+		w := ow.build()
+		_, wantFlusher := w.(http.Flusher)
+		_, wantHijacker := w.(http.Hijacker)
+		_, wantReaderFrom := w.(io.ReaderFrom)
+		_, wantPusher := w.(http.Pusher)
+
+		tw := WrapResponseWriter(w)
+		_, isFlusher := tw.(http.Flusher)
+		_, isHijacker := tw.(http.Hijacker)
+		_, isReaderFrom := tw.(io.ReaderFrom)
+		_, isPusher := tw.(http.Pusher)
+
+		name := fmt.Sprintf("%+v", ow)
+		assert.Check(t, is.Equal(wantFlusher, isFlusher), name)
+		assert.Check(t, is.Equal(wantHijacker, isHijacker), name)
+		assert.Check(t, is.Equal(wantReaderFrom, isReaderFrom), name)
+		assert.Check(t, is.Equal(wantPusher, isPusher), name)
+	}
+}
+
+func TestWrapResponseWriter(t *testing.T) {
+	t.Run("write", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		tw := WrapResponseWriter(rec)
+		assert.Check(t, !tw.Committed())
+		assert.Check(t, is.Equal(0, tw.Status()))
+
+		tw.WriteHeader(http.StatusCreated)
+		tw.WriteHeader(http.StatusAccepted)
+		_, err := tw.Write([]byte("Hello, World!"))
+		assert.NilError(t, err)
+		assert.Check(t, tw.Committed())
+		assert.Check(t, is.Equal(http.StatusCreated, tw.Status()))
+		assert.Check(t, is.Equal(int64(13), tw.BytesWritten()))
+	})
+
+	t.Run("read from", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		tw := WrapResponseWriter(optionalWriter{ResponseWriter: rec, readerFrom: true}.build())
+		n, err := tw.(io.ReaderFrom).ReadFrom(strings.NewReader("Hello, World!"))
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(int64(13), n))
+		assert.Check(t, is.Equal(int64(13), tw.BytesWritten()))
+		assert.Check(t, is.Equal(http.StatusOK, tw.Status()))
+		assert.Check(t, is.Equal("Hello, World!", rec.Body.String()))
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		tw := WrapResponseWriter(rec)
+		tw.(http.Flusher).Flush()
+		assert.Check(t, tw.Committed())
+		assert.Check(t, rec.Flushed)
+	})
+
+	t.Run("unwrap", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		tw := WrapResponseWriter(rec)
+		assert.Check(t, is.Equal(http.ResponseWriter(rec), tw.Unwrap()))
+		assert.Check(t, is.Equal(tw, WrapResponseWriter(tw)))
+	})
+
+	t.Run("httperr.Write", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw := WrapResponseWriter(w)
+			_, isHijacker := tw.(http.Hijacker)
+			assert.Check(t, isHijacker)
+			_, _ = tw.Write([]byte("partial"))
+			httperr.Write(tw, r, httperr.Publicf(http.StatusConflict, "too late"))
+		}))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(http.StatusOK, resp.StatusCode))
+		assert.Check(t, is.Equal("", resp.Header.Get("X-Error-Message")))
+		assert.Check(t, is.Equal("partial", string(body)))
+	})
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryMinBackoff  = 100 * time.Millisecond
+	defaultRetryMaxBackoff  = 10 * time.Second
+)
+
+// RetryTransport is an http.RoundTripper that retries idempotent requests that
+// fail with a connection error, a 429 Too Many Requests or a 5xx response.
+//
+// Between attempts it waits using jittered exponential backoff, unless the
+// server sent a Retry-After header, in which case that delay is used instead.
+// Request bodies are rewound using http.Request.GetBody, so requests created by
+// http.NewRequest or JSONClient.NewRequest can be retried.
+//
+// e.g.
+//
+//   transport := RetryTransport{Next: http.DefaultTransport, MaxAttempts: 5}
+//
+type RetryTransport struct {
+	Next http.RoundTripper
+
+	// MaxAttempts is the maximum number of times a request is sent, including
+	// the first attempt. If zero, 3 attempts are made.
+	MaxAttempts int
+
+	// MaxElapsed, if non-zero, limits the total time spent on a request,
+	// including time spent waiting between attempts. No retry is made if the
+	// wait would exceed the limit.
+	MaxElapsed time.Duration
+
+	// MinBackoff is the backoff before the first retry. It doubles on each
+	// subsequent retry up to MaxBackoff. If zero, 100ms and 10s are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t RetryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	start := time.Now()
+
+	maxAttempts := t.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultRetryMaxAttempts
+	}
+	if !isIdempotent(r) {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		req := r.Clone(ctx)
+		if attempt > 1 && r.Body != nil && r.Body != http.NoBody {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := t.Next.RoundTrip(req)
+		if attempt >= maxAttempts || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+		if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+			return resp, err
+		}
+
+		delay, ok := retryAfter(resp, time.Now())
+		if !ok {
+			delay = t.backoff(attempt)
+		}
+		if t.MaxElapsed > 0 && time.Now().Add(delay).Sub(start) > t.MaxElapsed {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a random delay between zero and the exponential backoff for
+// the given attempt.
+func (t RetryTransport) backoff(attempt int) time.Duration {
+	minBackoff, maxBackoff := t.MinBackoff, t.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultRetryMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	d := minBackoff
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// isIdempotent returns true if the request can safely be sent more than once.
+// Like net/http, requests carrying an Idempotency-Key header are considered
+// idempotent regardless of method.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if _, ok := r.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	if _, ok := r.Header["X-Idempotency-Key"]; ok {
+		return true
+	}
+	return false
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode == http.StatusNotImplemented, resp.StatusCode == http.StatusHTTPVersionNotSupported:
+		return false
+	case resp.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by the Retry-After header of resp,
+// which can be either a number of seconds or an HTTP date.
+//
+// ref: https://httpwg.org/specs/rfc7231.html#header.retry-after
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		delay := at.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestRetryTransport(t *testing.T) {
+	t.Run("retries server errors and rewinds body", func(t *testing.T) {
+		attempts := 0
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.Check(t, err)
+			assert.Equal(t, `{"Foo":"foo"}`, string(reqBody))
+
+			resp := &http.Response{Header: http.Header{}}
+			if attempts < 3 {
+				resp.StatusCode = http.StatusServiceUnavailable
+				resp.Body = ioutil.NopCloser(strings.NewReader(`Service Unavailable`))
+				return resp, nil
+			}
+			resp.StatusCode = http.StatusOK
+			resp.Body = ioutil.NopCloser(strings.NewReader(`{"Bar": "baz"}`))
+			return resp, nil
+		})
+
+		c := JSONClient{Client: &http.Client{Transport: RetryTransport{
+			Next:       fakeTransport,
+			MinBackoff: time.Millisecond,
+		}}}
+		resp := ResponseBody{}
+		err := c.DoJSON(context.Background(), "PUT", "https://api.example.com/foo", RequestBody{Foo: "foo"}, &resp)
+		assert.Check(t, err)
+		assert.Equal(t, 3, attempts)
+		assert.DeepEqual(t, resp, ResponseBody{Bar: "baz"})
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		attempts := 0
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			return nil, errors.New("connection refused")
+		})
+
+		client := http.Client{Transport: RetryTransport{
+			Next:        fakeTransport,
+			MaxAttempts: 2,
+			MinBackoff:  time.Millisecond,
+		}}
+		_, err := client.Get("https://api.example.com/foo")
+		assert.Check(t, is.ErrorContains(err, "connection refused"))
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("does not retry non-idempotent requests", func(t *testing.T) {
+		attempts := 0
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			resp := &http.Response{StatusCode: http.StatusBadGateway}
+			resp.Body = ioutil.NopCloser(strings.NewReader(`Bad Gateway`))
+			return resp, nil
+		})
+
+		client := http.Client{Transport: RetryTransport{Next: fakeTransport, MinBackoff: time.Millisecond}}
+		resp, err := client.Post("https://api.example.com/foo", "text/plain", strings.NewReader("hello"))
+		assert.Check(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		attempts := 0
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			resp := &http.Response{StatusCode: http.StatusNotFound}
+			resp.Body = ioutil.NopCloser(strings.NewReader(`Not Found`))
+			return resp, nil
+		})
+
+		client := http.Client{Transport: RetryTransport{Next: fakeTransport, MinBackoff: time.Millisecond}}
+		resp, err := client.Get("https://api.example.com/foo")
+		assert.Check(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("honors retry-after within budget", func(t *testing.T) {
+		attempts := 0
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+			resp.Header.Set("Retry-After", "3600")
+			resp.Body = ioutil.NopCloser(strings.NewReader(`Too Many Requests`))
+			return resp, nil
+		})
+
+		client := http.Client{Transport: RetryTransport{
+			Next:       fakeTransport,
+			MaxElapsed: time.Second,
+		}}
+		resp, err := client.Get("https://api.example.com/foo")
+		assert.Check(t, err)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		fakeTransport := FakeServer(func(r *http.Request) (*http.Response, error) {
+			cancel()
+			resp := &http.Response{StatusCode: http.StatusServiceUnavailable}
+			resp.Body = ioutil.NopCloser(strings.NewReader(`Service Unavailable`))
+			return resp, nil
+		})
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", "https://api.example.com/foo", nil)
+		resp, err := RetryTransport{Next: fakeTransport}.RoundTrip(req)
+		assert.Check(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in    string
+		delay time.Duration
+		ok    bool
+	}{
+		{in: "", ok: false},
+		{in: "120", delay: 2 * time.Minute, ok: true},
+		{in: "0", delay: 0, ok: true},
+		{in: "-1", ok: false},
+		{in: "Sat, 02 Jan 2021 03:05:05 GMT", delay: time.Minute, ok: true},
+		{in: "Sat, 02 Jan 2021 03:00:00 GMT", delay: 0, ok: true},
+		{in: "soon", ok: false},
+	}
+	for i, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", test.in)
+		delay, ok := retryAfter(resp, now)
+		assert.Check(t, is.Equal(test.ok, ok), "ok mismatch on test index %d", i)
+		assert.Check(t, is.Equal(test.delay, delay), "delay mismatch on test index %d", i)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is a bearer token.
+type Token struct {
+	Value string
+
+	// Expiry is when the token expires, or zero if it is unknown.
+	Expiry time.Time
+}
+
+// TokenSource is an interface for types that provide bearer tokens to BearerTokenTransport.
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// StaticTokenSource is a TokenSource that always returns the same token.
+type StaticTokenSource string
+
+// Token implements TokenSource
+func (s StaticTokenSource) Token(ctx context.Context) (Token, error) {
+	return Token{Value: string(s), Expiry: jwtExpiry(string(s))}, nil
+}
+
+// EnvTokenSource is a TokenSource that returns the value of the named environment variable.
+type EnvTokenSource string
+
+// Token implements TokenSource
+func (s EnvTokenSource) Token(ctx context.Context) (Token, error) {
+	value := os.Getenv(string(s))
+	if value == "" {
+		return Token{}, fmt.Errorf("environment variable %s is not set", string(s))
+	}
+	return Token{Value: value, Expiry: jwtExpiry(value)}, nil
+}
+
+// FileTokenSource is a TokenSource that reads a token from a file, such as a Kubernetes projected
+// service account token. The file is read again whenever its modification time or size changes,
+// so tokens rotated on disk are picked up.
+//
+// If the token is a JWT, its expiry is taken from the exp claim.
+type FileTokenSource struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	token   Token
+}
+
+// Token implements TokenSource
+func (s *FileTokenSource) Token(ctx context.Context) (Token, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return Token{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.Value != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.token, nil
+	}
+
+	buf, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return Token{}, err
+	}
+	value := strings.TrimSpace(string(buf))
+	if value == "" {
+		return Token{}, fmt.Errorf("%s is empty", s.Path)
+	}
+	s.token = Token{Value: value, Expiry: jwtExpiry(value)}
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return s.token, nil
+}
+
+// jwtExpiry returns the time in the exp claim of token if it is a JWT, or zero otherwise.
+// The signature is not verified.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(claims.Exp), 0)
+}
@@ -0,0 +1,282 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the trace ID as lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the span ID as lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext identifies a span, and is propagated between services in the traceparent
+// and tracestate headers.
+//
+// ref: https://www.w3.org/TR/trace-context/
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid returns true if neither the trace ID nor the span ID are zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent returns the value of the traceparent header for sc.
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent parses the value of a traceparent header.
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errors.New("invalid traceparent")
+	}
+	// future versions may add fields, but version 00 has exactly four
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, errors.New("invalid traceparent")
+	}
+	var version, flags [1]byte
+	for _, f := range []struct {
+		dst []byte
+		src string
+	}{{version[:], parts[0]}, {sc.TraceID[:], parts[1]}, {sc.SpanID[:], parts[2]}, {flags[:], parts[3]}} {
+		if strings.ToLower(f.src) != f.src {
+			return sc, errors.New("invalid traceparent")
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.src)); err != nil {
+			return sc, errors.New("invalid traceparent")
+		}
+	}
+	if !sc.IsValid() {
+		return sc, errors.New("invalid traceparent")
+	}
+	sc.Sampled = flags[0]&1 != 0
+	return sc, nil
+}
+
+type spanContextIndexType int
+
+const spanContextIndex spanContextIndexType = iota
+
+// ContextWithSpanContext returns a new context that holds sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextIndex, sc)
+}
+
+// SpanContextFromContext returns the SpanContext held by ctx, if there is one.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextIndex).(SpanContext)
+	return sc, ok
+}
+
+// Kinds of Span.
+const (
+	SpanKindServer = "server"
+	SpanKindClient = "client"
+)
+
+// Span is a finished operation in a trace.
+type Span struct {
+	SpanContext
+	ParentSpanID SpanID // zero if the span is the root of its trace
+	Name         string
+	Kind         string
+	Start        time.Time
+	End          time.Time
+	StatusCode   int    // zero if there was no response
+	Error        string // empty if there was no error
+}
+
+// SpanExporter sends finished spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpan(ctx context.Context, span Span)
+}
+
+// TraceTransport is an http.RoundTripper that propagates the SpanContext of the request
+// context in the traceparent and tracestate headers.
+//
+// If Exporter is not nil, each request is a client span, a child of the span in the
+// context, which is exported once the response header is received. Requests whose
+// context has no SpanContext are sent unchanged.
+//
+// e.g.
+//
+//   c := JSONClient{Client: &http.Client{Transport: TraceTransport{Next: http.DefaultTransport}}}
+//
+type TraceTransport struct {
+	Next     http.RoundTripper
+	Exporter SpanExporter
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t TraceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	parent, ok := SpanContextFromContext(ctx)
+	if !ok || !parent.IsValid() {
+		return t.Next.RoundTrip(r)
+	}
+
+	sc := parent
+	if t.Exporter != nil {
+		sc.SpanID = newSpanID()
+	}
+
+	r = r.Clone(ctx)
+	r.Header.Set("traceparent", sc.TraceParent())
+	if sc.TraceState != "" {
+		r.Header.Set("tracestate", sc.TraceState)
+	} else {
+		r.Header.Del("tracestate")
+	}
+
+	if t.Exporter == nil {
+		return t.Next.RoundTrip(r)
+	}
+
+	span := Span{
+		SpanContext:  sc,
+		ParentSpanID: parent.SpanID,
+		Name:         r.Method + " " + r.URL.Host,
+		Kind:         SpanKindClient,
+		Start:        time.Now(),
+	}
+	resp, err := t.Next.RoundTrip(r)
+	span.End = time.Now()
+	if err != nil {
+		span.Error = err.Error()
+	} else {
+		span.StatusCode = resp.StatusCode
+	}
+	if sc.Sampled {
+		t.Exporter.ExportSpan(ctx, span)
+	}
+	return resp, err
+}
+
+// Trace returns middleware that starts a server span for each request, and stores its
+// SpanContext in the request context, where SpanContextFromContext and TraceTransport
+// can find it.
+//
+// If the request has a valid traceparent header, the span is its child, and tracestate
+// is preserved. Otherwise the span starts a new, sampled trace.
+//
+// If exporter is not nil, sampled spans are exported once the request has been served.
+// The span is named after the goji pattern that matched the request, e.g. "/users/:id",
+// so the middleware should be installed on a goji mux with Use. Its status code is that
+// of an error reported with httperr.ReportError, if there was one, and otherwise the
+// status code of the response.
+//
+// e.g.
+//
+//   mux := goji.NewMux()
+//   mux.Use(Trace(exporter))
+//
+func Trace(exporter SpanExporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span := Span{Kind: SpanKindServer, Start: time.Now()}
+			if parent, err := ParseTraceParent(r.Header.Get("traceparent")); err == nil {
+				span.SpanContext = parent
+				span.ParentSpanID = parent.SpanID
+				span.TraceState = strings.Join(r.Header.Values("tracestate"), ",")
+			} else {
+				span.TraceID = newTraceID()
+				span.Sampled = true
+			}
+			span.SpanID = newSpanID()
+
+			tw := WrapResponseWriter(w)
+			ctx := ContextWithSpanContext(r.Context(), span.SpanContext)
+			r = r.WithContext(ctx)
+			if exporter != nil {
+				r = httperr.ObserveError(r, func(err error) {
+					span.Error = err.Error()
+					span.StatusCode = httperr.StatusCode(err)
+					if span.StatusCode == 0 {
+						span.StatusCode = http.StatusInternalServerError
+					}
+				})
+			}
+			next.ServeHTTP(tw, r)
+
+			if exporter == nil || !span.Sampled {
+				return
+			}
+			span.End = time.Now()
+			span.Name = routePattern(r)
+			if span.StatusCode == 0 {
+				span.StatusCode = tw.Status()
+			}
+			if span.StatusCode == 0 {
+				span.StatusCode = http.StatusOK
+			}
+			exporter.ExportSpan(ctx, span)
+		})
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		mustReadRandom(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		mustReadRandom(id[:])
+	}
+	return id
+}
+
+func mustReadRandom(buf []byte) {
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("cannot read random bytes: %v", err))
+	}
+}
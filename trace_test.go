@@ -0,0 +1,173 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"goji.io"
+	"goji.io/pat"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+type testExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (e *testExporter) ExportSpan(ctx context.Context, span Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String()))
+	assert.Check(t, is.Equal("00f067aa0ba902b7", sc.SpanID.String()))
+	assert.Check(t, sc.Sampled)
+	assert.Check(t, is.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent()))
+
+	sc, err = ParseTraceParent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-what-the-future-will-be-like")
+	assert.NilError(t, err)
+	assert.Check(t, !sc.Sampled)
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceParent(s)
+		assert.Check(t, is.ErrorContains(err, "invalid traceparent"), s)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	exporter := &testExporter{}
+
+	var upstreamHeader http.Header
+	upstream := FakeServer(func(r *http.Request) (*http.Response, error) {
+		upstreamHeader = r.Header.Clone()
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})
+	client := http.Client{Transport: TraceTransport{Next: upstream, Exporter: exporter}}
+
+	var handlerContext SpanContext
+	mux := goji.NewMux()
+	mux.Use(Trace(exporter))
+	mux.Handle(pat.Get("/users/:id"), httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		handlerContext, _ = SpanContextFromContext(r.Context())
+		req, err := http.NewRequestWithContext(r.Context(), "GET", "https://upstream.example.com/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if pat.Param(r, "id") == "missing" {
+			return httperr.NotFound
+		}
+		return nil
+	}))
+
+	// serve waits for the handler to finish, so that its span has been exported
+	serve := func(t *testing.T, r *http.Request) *http.Response {
+		resp, err := (&http.Client{Transport: HandlerTransport{Handler: mux}}).Do(r)
+		assert.NilError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		return resp
+	}
+
+	t.Run("child of incoming", func(t *testing.T) {
+		exporter.spans = nil
+		r, err := http.NewRequest("GET", "https://api.example.com/users/1", nil)
+		assert.NilError(t, err)
+		r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		r.Header.Set("tracestate", "congo=t61rcWkgMzE")
+		resp := serve(t, r)
+		assert.Check(t, is.Equal(http.StatusOK, resp.StatusCode))
+
+		assert.Check(t, is.Equal("4bf92f3577b34da6a3ce929d0e0e4736", handlerContext.TraceID.String()))
+		assert.Check(t, handlerContext.SpanID.String() != "00f067aa0ba902b7")
+		assert.Check(t, is.Equal("congo=t61rcWkgMzE", handlerContext.TraceState))
+
+		assert.Assert(t, is.Len(exporter.spans, 2))
+		clientSpan, serverSpan := exporter.spans[0], exporter.spans[1]
+
+		assert.Check(t, is.Equal(SpanKindServer, serverSpan.Kind))
+		assert.Check(t, is.Equal("/users/:id", serverSpan.Name))
+		assert.Check(t, is.Equal(http.StatusOK, serverSpan.StatusCode))
+		assert.Check(t, is.Equal("00f067aa0ba902b7", serverSpan.ParentSpanID.String()))
+		assert.Check(t, is.Equal(handlerContext, serverSpan.SpanContext))
+
+		assert.Check(t, is.Equal(SpanKindClient, clientSpan.Kind))
+		assert.Check(t, is.Equal("GET upstream.example.com", clientSpan.Name))
+		assert.Check(t, is.Equal(http.StatusAccepted, clientSpan.StatusCode))
+		assert.Check(t, is.Equal(serverSpan.SpanID, clientSpan.ParentSpanID))
+		assert.Check(t, is.Equal(clientSpan.TraceParent(), upstreamHeader.Get("traceparent")))
+		assert.Check(t, is.Equal("congo=t61rcWkgMzE", upstreamHeader.Get("tracestate")))
+	})
+
+	t.Run("new trace", func(t *testing.T) {
+		exporter.spans = nil
+		r, err := http.NewRequest("GET", "https://api.example.com/users/missing", nil)
+		assert.NilError(t, err)
+		resp := serve(t, r)
+		assert.Check(t, is.Equal(http.StatusNotFound, resp.StatusCode))
+
+		assert.Assert(t, is.Len(exporter.spans, 2))
+		serverSpan := exporter.spans[1]
+		assert.Check(t, serverSpan.IsValid())
+		assert.Check(t, serverSpan.Sampled)
+		assert.Check(t, is.Equal(SpanID{}, serverSpan.ParentSpanID))
+		assert.Check(t, is.Equal(http.StatusNotFound, serverSpan.StatusCode))
+		assert.Check(t, is.Equal("Not Found", serverSpan.Error))
+		assert.Check(t, is.Equal("", upstreamHeader.Get("tracestate")))
+	})
+
+	t.Run("not sampled", func(t *testing.T) {
+		exporter.spans = nil
+		r, err := http.NewRequest("GET", "https://api.example.com/users/1", nil)
+		assert.NilError(t, err)
+		r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		serve(t, r)
+		assert.Check(t, is.Len(exporter.spans, 0))
+		assert.Check(t, strings.HasSuffix(upstreamHeader.Get("traceparent"), "-00"))
+	})
+
+	t.Run("no context", func(t *testing.T) {
+		upstreamHeader = nil
+		_, err := client.Get("https://upstream.example.com/")
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal("", upstreamHeader.Get("traceparent")))
+	})
+}
@@ -0,0 +1,539 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+// Validator is an interface for JSONHandler input types that check themselves after
+// the request has been decoded. It is invoked after the `validate` struct tags have
+// been checked, on the input and on every nested struct.
+type Validator interface {
+	Validate() error
+}
+
+// FieldViolation describes a single invalid field of a request. Pointer is an RFC 6901
+// JSON pointer to the field in the request body. For fields bound from the path, query
+// or headers, Parameter is set instead.
+type FieldViolation struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+	Message   string `json:"message"`
+}
+
+// ValidationError is a public 422 Unprocessable Entity error returned by JSONHandler when
+// the input fails validation. It renders as an application/problem+json document with the
+// violations in the "errors" member.
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+var _ httperr.ResponseWriter = ValidationError{}
+var _ httperr.StatusCoder = ValidationError{}
+var _ httperr.IsPublicer = ValidationError{}
+
+func (e ValidationError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		field := v.Pointer
+		if field == "" {
+			field = v.Parameter
+		}
+		parts[i] = field + ": " + v.Message
+	}
+	return "invalid request: " + strings.Join(parts, "; ")
+}
+
+// StatusCode implements httperr.StatusCoder
+func (e ValidationError) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+// IsPublic implements httperr.IsPublicer
+func (e ValidationError) IsPublic() bool {
+	return true
+}
+
+// ProblemExtensions implements httperr.ProblemExtender
+func (e ValidationError) ProblemExtensions() map[string]interface{} {
+	return map[string]interface{}{"errors": e.Violations}
+}
+
+// WriteResponse implements httperr.ResponseWriter
+func (e ValidationError) WriteResponse(w http.ResponseWriter, r *http.Request) {
+	httperr.WriteProblem(w, r, e)
+}
+
+// validateInput checks the `validate` struct tags of v and then invokes Validate() on every
+// struct that implements Validator. Tag violations are returned as a ValidationError. Errors
+// returned by Validate() are returned as-is if they are a ValidationError or carry a status
+// code, otherwise they are returned as public 422 errors.
+//
+// The validate tag is a comma separated list of rules:
+//
+//   required     the value must not be the zero value, nil, or empty
+//   min=N        numbers must be >= N; strings, slices and maps must have length >= N
+//   max=N        numbers must be <= N; strings, slices and maps must have length <= N
+//   len=N        strings, slices and maps must have length N
+//   oneof=a b c  the value must be one of the space separated values
+//   pattern=re   strings must match the regular expression re, which extends to the end of the tag
+//
+// Rules other than required are skipped for nil pointers. JSONHandler and its generic variants
+// panic if the tags of their input type are malformed.
+func validateInput(v interface{}) error {
+	var violations []FieldViolation
+	if err := validateValue(reflect.ValueOf(v), "", &violations); err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return ValidationError{Violations: violations}
+	}
+
+	if err := callValidators(reflect.ValueOf(v)); err != nil {
+		var ve ValidationError
+		if errors.As(err, &ve) || httperr.StatusCode(err) != 0 {
+			return err
+		}
+		return httperr.Public(http.StatusUnprocessableEntity, err)
+	}
+	return nil
+}
+
+// validateValue checks the struct tags of fields of v, recursing into nested structs, slices and maps.
+func validateValue(v reflect.Value, pointer string, violations *[]FieldViolation) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), pointer+"/"+strconv.Itoa(i), violations); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key())
+			if err := validateValue(iter.Value(), pointer+"/"+escapeJSONPointer(key), violations); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				continue // unexported
+			}
+
+			fieldPointer, parameter := pointer, ""
+			name, inJSON := jsonFieldName(field)
+			switch {
+			case field.Anonymous && name == "":
+				// embedded structs are flattened
+			case !inJSON:
+				parameter = boundParameterName(field)
+				if parameter == "" {
+					continue
+				}
+			default:
+				fieldPointer = pointer + "/" + escapeJSONPointer(name)
+			}
+
+			if tag, ok := field.Tag.Lookup("validate"); ok {
+				message, err := checkRules(v.Field(i), tag)
+				if err != nil {
+					return fmt.Errorf("%s.%s: %w", t, field.Name, err)
+				}
+				if message != "" {
+					violation := FieldViolation{Message: message}
+					if parameter != "" {
+						violation.Parameter = parameter
+					} else {
+						violation.Pointer = fieldPointer
+					}
+					*violations = append(*violations, violation)
+					continue
+				}
+			}
+
+			if parameter == "" {
+				if err := validateValue(v.Field(i), fieldPointer, violations); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonFieldName returns the name of field in its JSON encoding, or false if the field
+// is omitted from JSON. The name is empty for embedded structs without a json tag.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" && !field.Anonymous {
+		name = field.Name
+	}
+	return name, true
+}
+
+// boundParameterName returns the name of the path, query or header value the field is bound
+// from by bindRequest, if any.
+func boundParameterName(field reflect.StructField) string {
+	for _, source := range []string{"path", "query", "header"} {
+		if name, ok := field.Tag.Lookup(source); ok && name != "" && name != "-" {
+			return name
+		}
+	}
+	return ""
+}
+
+// escapeJSONPointer escapes a reference token of a JSON pointer.
+//
+// ref: https://www.rfc-editor.org/rfc/rfc6901#section-3
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// validationRule is a single rule of a validate tag, e.g. "max=5".
+type validationRule struct {
+	name string
+	arg  string
+}
+
+// parseRules splits a validate tag into its rules.
+func parseRules(tag string) []validationRule {
+	var rules []validationRule
+	for tag != "" {
+		var rule string
+		if strings.HasPrefix(tag, "pattern=") {
+			rule, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			rule, tag = tag, ""
+		}
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		rules = append(rules, validationRule{name: name, arg: arg})
+	}
+	return rules
+}
+
+// checkRules evaluates the rules of a validate tag against v. It returns a message describing
+// the first violated rule, or an error if the tag is malformed.
+func checkRules(v reflect.Value, tag string) (string, error) {
+	for _, rule := range parseRules(tag) {
+		name, arg := rule.name, rule.arg
+		if name == "required" {
+			if isEmptyValue(v) {
+				return "is required", nil
+			}
+			continue
+		}
+
+		elem := v
+		for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface {
+			if elem.IsNil() {
+				return "", nil
+			}
+			elem = elem.Elem()
+		}
+
+		message, err := checkRule(elem, name, arg)
+		if err != nil || message != "" {
+			return message, err
+		}
+	}
+	return "", nil
+}
+
+func checkRule(v reflect.Value, name string, arg string) (string, error) {
+	switch name {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s rule: %w", name, err)
+		}
+
+		var actual float64
+		var isLength bool
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			actual = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			actual = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			actual = v.Float()
+		case reflect.String:
+			actual, isLength = float64(utf8.RuneCountInString(v.String())), true
+		case reflect.Slice, reflect.Array, reflect.Map:
+			actual, isLength = float64(v.Len()), true
+		default:
+			return "", fmt.Errorf("%s rule does not apply to %s", name, v.Type())
+		}
+
+		switch {
+		case name == "len" && actual != limit:
+			return "must have length " + arg, nil
+		case name == "min" && actual < limit && isLength:
+			return "must have length at least " + arg, nil
+		case name == "min" && actual < limit:
+			return "must be at least " + arg, nil
+		case name == "max" && actual > limit && isLength:
+			return "must have length at most " + arg, nil
+		case name == "max" && actual > limit:
+			return "must be at most " + arg, nil
+		}
+	case "oneof":
+		actual := fmt.Sprint(v)
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if actual == option {
+				return "", nil
+			}
+		}
+		return "must be one of " + strings.Join(options, ", "), nil
+	case "pattern":
+		if v.Kind() != reflect.String {
+			return "", fmt.Errorf("pattern rule does not apply to %s", v.Type())
+		}
+		re, err := compilePattern(arg)
+		if err != nil {
+			return "", err
+		}
+		if !re.MatchString(v.String()) {
+			return "must match " + arg, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown validation rule %q", name)
+	}
+	return "", nil
+}
+
+// mustCheckValidateTags panics if the validate tags of the input type t are malformed, so that
+// mistakes are found when a handler is created rather than by each request.
+func mustCheckValidateTags(t reflect.Type) {
+	if err := checkValidateTags(t, map[reflect.Type]bool{}); err != nil {
+		panic(err.Error())
+	}
+}
+
+// checkValidateTags returns an error if a validate tag of the fields of t, or of the types
+// nested in it, is malformed or has a rule that does not apply to the type of its field. It
+// checks the same fields as validateValue.
+func checkValidateTags(t reflect.Type, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return checkValidateTags(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				continue // unexported
+			}
+
+			parameter := ""
+			if name, inJSON := jsonFieldName(field); !inJSON && !(field.Anonymous && name == "") {
+				parameter = boundParameterName(field)
+				if parameter == "" {
+					continue
+				}
+			}
+
+			if tag, ok := field.Tag.Lookup("validate"); ok {
+				if err := checkRuleTypes(field.Type, tag); err != nil {
+					return fmt.Errorf("%s.%s: %w", t, field.Name, err)
+				}
+			}
+			if parameter == "" {
+				if err := checkValidateTags(field.Type, seen); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkRuleTypes returns the error that checkRules would return for a field of type t with
+// the validate tag, without a value.
+func checkRuleTypes(t reflect.Type, tag string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, rule := range parseRules(tag) {
+		switch rule.name {
+		case "required", "oneof":
+		case "min", "max", "len":
+			if _, err := strconv.ParseFloat(rule.arg, 64); err != nil {
+				return fmt.Errorf("invalid %s rule: %w", rule.name, err)
+			}
+			switch t.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+				reflect.Float32, reflect.Float64, reflect.String, reflect.Slice, reflect.Array,
+				reflect.Map, reflect.Interface:
+			default:
+				return fmt.Errorf("%s rule does not apply to %s", rule.name, t)
+			}
+		case "pattern":
+			if t.Kind() != reflect.String && t.Kind() != reflect.Interface {
+				return fmt.Errorf("pattern rule does not apply to %s", t)
+			}
+			if _, err := compilePattern(rule.arg); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown validation rule %q", rule.name)
+		}
+	}
+	return nil
+}
+
+// isEmptyValue returns true if v is the zero value, or an empty slice or map.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+var patternCache sync.Map // map[string]*regexp.Regexp
+
+func compilePattern(s string) (*regexp.Regexp, error) {
+	if re, ok := patternCache.Load(s); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Store(s, re)
+	return re, nil
+}
+
+var validatorType = reflect.TypeOf((*Validator)(nil)).Elem()
+
+// callValidators invokes Validate() on v and every nested value that implements Validator,
+// innermost first, returning the first error.
+//
+// Values reached through unexported fields are skipped. The Validate() method of an embedded
+// field is not called separately if the enclosing struct has a Validate() method, since that
+// method is either promoted from the embedded field or overrides it.
+func callValidators(v reflect.Value) error {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		return callValidators(v.Elem())
+	}
+
+	if err := callNestedValidators(v); err != nil {
+		return err
+	}
+	if validator, ok := asValidator(v); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// callNestedValidators is like callValidators, but does not invoke Validate() on v itself.
+func callNestedValidators(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return callNestedValidators(v.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := callValidators(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := callValidators(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		_, hasValidate := asValidator(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				continue // unexported
+			}
+			var err error
+			if field.Anonymous && hasValidate {
+				err = callNestedValidators(v.Field(i))
+			} else {
+				err = callValidators(v.Field(i))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// asValidator returns v, or its address, as a Validator. It returns false if v does not
+// implement Validator, or was obtained through an unexported field.
+func asValidator(v reflect.Value) (Validator, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(validatorType) {
+		return v.Addr().Interface().(Validator), true
+	}
+	if v.Type().Implements(validatorType) {
+		return v.Interface().(Validator), true
+	}
+	return nil, false
+}
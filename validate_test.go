@@ -0,0 +1,200 @@
+// Copyright 2020 Nametag, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	"github.com/nametaginc/httpx/httperr"
+)
+
+type ValidatedItem struct {
+	SKU      string `json:"sku" validate:"required,pattern=^[A-Z]{3}-[0-9]+$"`
+	Quantity int    `json:"quantity" validate:"min=1,max=10"`
+}
+
+type ValidatedInput struct {
+	Limit  int             `query:"limit" json:"-" validate:"max=100"`
+	Name   string          `json:"name" validate:"required,max=5"`
+	Color  string          `json:"color,omitempty" validate:"oneof=red green blue"`
+	Note   *string         `json:"note" validate:"min=2"`
+	Items  []ValidatedItem `json:"items" validate:"min=1"`
+	Secret string          `json:"-"`
+}
+
+func (in ValidatedInput) Validate() error {
+	if in.Name == "admin" {
+		return errors.New("name is reserved")
+	}
+	return nil
+}
+
+type validatedBase struct {
+	Tenant string `json:"tenant" validate:"oneof=acme initech"`
+	calls  int
+}
+
+func (b *validatedBase) Validate() error {
+	b.calls++
+	if b.Tenant == "initech" {
+		return errors.New("tenant is suspended")
+	}
+	return nil
+}
+
+type EmbeddedValidatedInput struct {
+	validatedBase
+	Name string `json:"name"`
+}
+
+func TestValidateInput(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		in := ValidatedInput{
+			Name:  "frob",
+			Color: "red",
+			Items: []ValidatedItem{{SKU: "ABC-1", Quantity: 2}},
+		}
+		assert.Check(t, validateInput(&in))
+	})
+
+	t.Run("violations", func(t *testing.T) {
+		note := "x"
+		in := ValidatedInput{
+			Limit: 1000,
+			Name:  "",
+			Color: "purple",
+			Note:  &note,
+			Items: []ValidatedItem{{SKU: "ABC-1", Quantity: 2}, {SKU: "abc", Quantity: 0}},
+		}
+		err := validateInput(&in)
+		var ve ValidationError
+		assert.Assert(t, errors.As(err, &ve))
+		assert.DeepEqual(t, ve.Violations, []FieldViolation{
+			{Parameter: "limit", Message: "must be at most 100"},
+			{Pointer: "/name", Message: "is required"},
+			{Pointer: "/color", Message: "must be one of red, green, blue"},
+			{Pointer: "/note", Message: "must have length at least 2"},
+			{Pointer: "/items/1/sku", Message: "must match ^[A-Z]{3}-[0-9]+$"},
+			{Pointer: "/items/1/quantity", Message: "must be at least 1"},
+		})
+		assert.Check(t, is.Equal(http.StatusUnprocessableEntity, httperr.StatusCode(err)))
+		assert.Check(t, httperr.IsPublic(err))
+	})
+
+	t.Run("validator", func(t *testing.T) {
+		in := ValidatedInput{Name: "admin", Color: "red", Items: []ValidatedItem{{SKU: "ABC-1", Quantity: 1}}}
+		err := validateInput(&in)
+		assert.Check(t, is.Error(err, "name is reserved"))
+		assert.Check(t, is.Equal(http.StatusUnprocessableEntity, httperr.StatusCode(err)))
+		assert.Check(t, httperr.IsPublic(err))
+	})
+
+	t.Run("embedded validator", func(t *testing.T) {
+		in := EmbeddedValidatedInput{validatedBase: validatedBase{Tenant: "acme"}, Name: "frob"}
+		assert.Check(t, validateInput(&in))
+		assert.Check(t, is.Equal(1, in.calls))
+
+		in = EmbeddedValidatedInput{validatedBase: validatedBase{Tenant: "initech"}, Name: "frob"}
+		assert.Check(t, is.Error(validateInput(&in), "tenant is suspended"))
+
+		in = EmbeddedValidatedInput{validatedBase: validatedBase{Tenant: "globex"}, Name: "frob"}
+		var ve ValidationError
+		assert.Assert(t, errors.As(validateInput(&in), &ve))
+		assert.DeepEqual(t, ve.Violations, []FieldViolation{
+			{Pointer: "/tenant", Message: "must be one of acme, initech"},
+		})
+	})
+
+	t.Run("embedded validator handler", func(t *testing.T) {
+		h := JSONInputHandlerFunc(func(r *http.Request, in EmbeddedValidatedInput) error {
+			return nil
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/frob", strings.NewReader(`{"tenant": "initech", "name": "frob"}`)))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("malformed tag", func(t *testing.T) {
+		in := struct {
+			Name string `validate:"frobnicate"`
+		}{}
+		err := validateInput(&in)
+		assert.Check(t, is.ErrorContains(err, `unknown validation rule "frobnicate"`))
+		assert.Check(t, is.Equal(0, httperr.StatusCode(err)))
+	})
+
+	t.Run("malformed tag panics at startup", func(t *testing.T) {
+		type BadRule struct {
+			Name string `json:"name" validate:"frobnicate"`
+		}
+		type BadLimit struct {
+			Items []struct {
+				Count int `validate:"min=lots"`
+			}
+		}
+		type BadPattern struct {
+			Code string `validate:"pattern=("`
+		}
+		type BadKind struct {
+			Active bool `query:"active" json:"-" validate:"max=1"`
+		}
+		type Ignored struct {
+			Secret string `json:"-" validate:"frobnicate"`
+		}
+
+		assert.Check(t, is.Panics(func() {
+			JSONInputHandlerFunc(func(r *http.Request, in BadRule) error { return nil })
+		}))
+		assert.Check(t, is.Panics(func() {
+			JSONHandlerFunc(func(r *http.Request, in *BadLimit) (*TestOutputType, error) { return nil, nil })
+		}))
+		assert.Check(t, is.Panics(func() {
+			JSONHandler(func(r *http.Request, in BadPattern) error { return nil })
+		}))
+		assert.Check(t, is.Panics(func() {
+			JSONHandler(func(r *http.Request, in *BadKind) error { return nil })
+		}))
+		JSONInputHandlerFunc(func(r *http.Request, in Ignored) error { return nil })
+		JSONInputHandlerFunc(func(r *http.Request, in ValidatedInput) error { return nil })
+	})
+
+	t.Run("handler response", func(t *testing.T) {
+		h := JSONHandlerFunc(func(r *http.Request, in ValidatedInput) (*TestOutputType, error) {
+			panic("not reached")
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/frob", strings.NewReader(`{"name": "frob", "color": "red", "items": []}`))
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, httperr.ProblemContentType, w.Header().Get("Content-Type"))
+
+		var p httperr.Problem
+		assert.Check(t, json.Unmarshal(w.Body.Bytes(), &p))
+		assert.Check(t, is.Equal("invalid request: /items: must have length at least 1", p.Detail))
+		assert.Check(t, is.DeepEqual(map[string]interface{}{
+			"errors": []interface{}{
+				map[string]interface{}{"pointer": "/items", "message": "must have length at least 1"},
+			},
+		}, p.Extensions))
+	})
+}